@@ -12,7 +12,7 @@ import (
 func tokNeedsDelim(t tokenType) bool {
 	switch t {
 	case tokenString, tokenListStart, tokenListEnd, tokenVectorStart,
-		tokenVectorEnd, tokenMapEnd, tokenMapStart, tokenSetStart, tokenDiscard, tokenError:
+		tokenVectorEnd, tokenMapEnd, tokenMapStart, tokenSetStart, tokenDiscard, tokenMeta, tokenError:
 		return false
 	}
 	return true
@@ -55,6 +55,10 @@ func Compact(dst *bytes.Buffer, src []byte) error {
 			return lex.err
 		case lexEnd:
 			// here we might want to discard #_ and the like. Currently we don't.
+			if lex.token == tokenMeta && needsDelim {
+				// literals and tags cannot be followed directly by ^
+				dst.WriteRune(prevIgnore)
+			}
 			dst.Write(src[start:pos])
 			needsDelim = tokNeedsDelim(lex.token)
 			lex.reset()
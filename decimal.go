@@ -0,0 +1,220 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A Decimal is an arbitrary precision decimal number, the equivalent of
+// Clojure's BigDecimal. Its value is unscaled × 10^-scale, so 1.10 has the
+// unscaled value 110 and scale 2. Unlike big.Float, a Decimal represents
+// decimal fractions such as 0.1 exactly, and it keeps trailing zeros.
+//
+// Decimals encode as EDN floats with the M suffix. Any EDN integer or float
+// can be decoded into a Decimal, and a Decoder can decode floats with the M
+// suffix into Decimals when decoding into an empty interface, see
+// Decoder.UseDecimals.
+//
+// Like the types in math/big, the methods of Decimal set the receiver to the
+// result of the operation and return it. The zero value of a Decimal is 0.
+type Decimal struct {
+	unscaled big.Int
+	scale    int32
+}
+
+// NewDecimal returns a new Decimal with the value unscaled × 10^-scale.
+func NewDecimal(unscaled *big.Int, scale int32) *Decimal {
+	z := &Decimal{scale: scale}
+	z.unscaled.Set(unscaled)
+	return z
+}
+
+// ParseDecimal parses s as a Decimal. s is a number in EDN syntax, such as
+// 1.10M, -3 or 1.5e-3, where the M suffix is optional.
+func ParseDecimal(s string) (*Decimal, error) {
+	z, ok := new(Decimal).SetString(s)
+	if !ok {
+		return nil, &UnmarshalTypeError{Value: "number " + strconv.Quote(s), Type: decimalType}
+	}
+	return z, nil
+}
+
+// SetString sets z to the value of s and returns z and a boolean indicating
+// success. s has the same format as for ParseDecimal. If SetString fails, the
+// value of z is undefined but the returned value is nil.
+func (z *Decimal) SetString(s string) (*Decimal, bool) {
+	s = strings.TrimSuffix(s, "M")
+	mant, exp := s, int64(0)
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.ParseInt(s[i+1:], 10, 32)
+		if err != nil {
+			return nil, false
+		}
+		mant, exp = s[:i], e
+	}
+	scale := int64(0)
+	if i := strings.IndexByte(mant, '.'); i >= 0 {
+		scale = int64(len(mant) - i - 1)
+		mant = mant[:i] + mant[i+1:]
+	}
+	if _, ok := z.unscaled.SetString(mant, 10); !ok {
+		return nil, false
+	}
+	scale -= exp
+	if scale != int64(int32(scale)) {
+		return nil, false
+	}
+	z.scale = int32(scale)
+	return z, true
+}
+
+// Set sets z to x and returns z.
+func (z *Decimal) Set(x *Decimal) *Decimal {
+	z.unscaled.Set(&x.unscaled)
+	z.scale = x.scale
+	return z
+}
+
+// Unscaled returns the unscaled value of x.
+func (x *Decimal) Unscaled() *big.Int {
+	return new(big.Int).Set(&x.unscaled)
+}
+
+// Scale returns the scale of x, the number of digits after the decimal point.
+// A negative scale means that the unscaled value is multiplied by a power of
+// ten.
+func (x *Decimal) Scale() int32 {
+	return x.scale
+}
+
+// Sign returns -1, 0 or +1 depending on whether x is negative, zero or
+// positive.
+func (x *Decimal) Sign() int {
+	return x.unscaled.Sign()
+}
+
+// Cmp compares x and y by their numeric value and returns -1, 0 or +1
+// depending on whether x is less than, equal to or greater than y. Decimals
+// with different scales may be equal, e.g. 1.10 and 1.1.
+func (x *Decimal) Cmp(y *Decimal) int {
+	xu, yu, _ := align(x, y)
+	return xu.Cmp(yu)
+}
+
+// Neg sets z to -x and returns z.
+func (z *Decimal) Neg(x *Decimal) *Decimal {
+	z.unscaled.Neg(&x.unscaled)
+	z.scale = x.scale
+	return z
+}
+
+// Add sets z to the sum x+y and returns z. The scale of the result is the
+// larger of the scales of x and y.
+func (z *Decimal) Add(x, y *Decimal) *Decimal {
+	xu, yu, scale := align(x, y)
+	z.unscaled.Add(xu, yu)
+	z.scale = scale
+	return z
+}
+
+// Sub sets z to the difference x-y and returns z. The scale of the result is
+// the larger of the scales of x and y.
+func (z *Decimal) Sub(x, y *Decimal) *Decimal {
+	xu, yu, scale := align(x, y)
+	z.unscaled.Sub(xu, yu)
+	z.scale = scale
+	return z
+}
+
+// Mul sets z to the product x*y and returns z. The scale of the result is the
+// sum of the scales of x and y.
+func (z *Decimal) Mul(x, y *Decimal) *Decimal {
+	z.unscaled.Mul(&x.unscaled, &y.unscaled)
+	z.scale = x.scale + y.scale
+	return z
+}
+
+// Rat returns the exact value of x as a big.Rat.
+func (x *Decimal) Rat() *big.Rat {
+	if x.scale < 0 {
+		n := pow10(-int64(x.scale))
+		return new(big.Rat).SetInt(n.Mul(n, &x.unscaled))
+	}
+	return new(big.Rat).SetFrac(&x.unscaled, pow10(int64(x.scale)))
+}
+
+// Float64 returns the float64 value nearest to x, and a bool indicating
+// whether it represents x exactly.
+func (x *Decimal) Float64() (float64, bool) {
+	return x.Rat().Float64()
+}
+
+// String returns x in decimal notation with exactly Scale digits after the
+// decimal point, e.g. 1.10. If the scale is negative, String uses an exponent
+// instead, e.g. 11E+2.
+func (x *Decimal) String() string {
+	s := x.unscaled.String()
+	switch {
+	case x.scale == 0:
+		return s
+	case x.scale < 0:
+		return s + "E+" + strconv.FormatInt(-int64(x.scale), 10)
+	}
+	sign := ""
+	if s[0] == '-' {
+		sign, s = "-", s[1:]
+	}
+	scale := int(x.scale)
+	if len(s) <= scale {
+		s = strings.Repeat("0", scale-len(s)+1) + s
+	}
+	return sign + s[:len(s)-scale] + "." + s[len(s)-scale:]
+}
+
+func (x Decimal) MarshalEDN() ([]byte, error) {
+	return []byte(x.String() + "M"), nil
+}
+
+func (z *Decimal) UnmarshalEDN(bs []byte) error {
+	s := strings.TrimSuffix(string(bs), "N")
+	if _, ok := z.SetString(s); !ok {
+		return &UnmarshalTypeError{Value: string(bs), Type: decimalType}
+	}
+	return nil
+}
+
+// UseDecimals makes the decoder decode floats with the M suffix into *Decimal
+// when decoding into an empty interface, instead of into *big.Float.
+func (d *Decoder) UseDecimals() {
+	d.decimals = true
+}
+
+var decimalType = reflect.TypeOf(Decimal{})
+
+// align returns the unscaled values of x and y with the same scale, and that
+// scale.
+func align(x, y *Decimal) (*big.Int, *big.Int, int32) {
+	switch {
+	case x.scale < y.scale:
+		return rescale(x, y.scale), &y.unscaled, y.scale
+	case x.scale > y.scale:
+		return &x.unscaled, rescale(y, x.scale), x.scale
+	}
+	return &x.unscaled, &y.unscaled, x.scale
+}
+
+// rescale returns the unscaled value of x with the larger scale scale.
+func rescale(x *Decimal, scale int32) *big.Int {
+	n := pow10(int64(scale) - int64(x.scale))
+	return n.Mul(n, &x.unscaled)
+}
+
+func pow10(n int64) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(n), nil)
+}
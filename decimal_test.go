@@ -0,0 +1,123 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestDecimalParseFormat(t *testing.T) {
+	tests := []struct {
+		in       string
+		unscaled int64
+		scale    int32
+		out      string
+	}{
+		{"1.10M", 110, 2, "1.10"},
+		{"-0.05", -5, 2, "-0.05"},
+		{"42", 42, 0, "42"},
+		{"1.5e-3M", 15, 4, "0.0015"},
+		{"11E+2M", 11, -2, "11E+2"},
+		{"+0.0", 0, 1, "0.0"},
+	}
+	for _, test := range tests {
+		d, err := ParseDecimal(test.in)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %v", test.in, err)
+			continue
+		}
+		if d.Unscaled().Int64() != test.unscaled || d.Scale() != test.scale {
+			t.Errorf("Expected %s to be %d×10^-%d, got %d×10^-%d",
+				test.in, test.unscaled, test.scale, d.Unscaled(), d.Scale())
+		}
+		if d.String() != test.out {
+			t.Errorf("Expected %s to format as %s, got %s", test.in, test.out, d)
+		}
+	}
+	for _, invalid := range []string{"", "1.2.3", "abc", "1e", "1.5N", "1e99999999999"} {
+		if _, err := ParseDecimal(invalid); err == nil {
+			t.Errorf("Expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	dec := func(s string) *Decimal {
+		d, err := ParseDecimal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	sum := new(Decimal).Add(dec("0.1"), dec("0.20"))
+	if sum.String() != "0.30" {
+		t.Errorf("Expected 0.1 + 0.20 = 0.30, got %s", sum)
+	}
+	if diff := new(Decimal).Sub(dec("1"), dec("0.01")); diff.String() != "0.99" {
+		t.Errorf("Expected 1 - 0.01 = 0.99, got %s", diff)
+	}
+	if prod := new(Decimal).Mul(dec("1.5"), dec("-0.20")); prod.String() != "-0.300" {
+		t.Errorf("Expected 1.5 * -0.20 = -0.300, got %s", prod)
+	}
+	if neg := new(Decimal).Neg(dec("2.5")); neg.String() != "-2.5" || neg.Sign() != -1 {
+		t.Errorf("Expected -2.5, got %s", neg)
+	}
+	if dec("1.10").Cmp(dec("1.1")) != 0 || dec("1.09").Cmp(dec("1.1")) != -1 || dec("1E+1").Cmp(dec("9.99")) != 1 {
+		t.Errorf("Expected decimals to compare by value")
+	}
+	if dec("0.25").Rat().Cmp(big.NewRat(1, 4)) != 0 || dec("3E+2").Rat().Cmp(big.NewRat(300, 1)) != 0 {
+		t.Errorf("Expected exact rational values")
+	}
+	if f, exact := dec("0.1").Float64(); f != 0.1 || exact {
+		t.Errorf("Expected inexact 0.1, got %v (exact: %v)", f, exact)
+	}
+}
+
+func TestDecimalEDN(t *testing.T) {
+	var s struct {
+		Price  Decimal
+		Tax    *Decimal
+		Amount *Decimal
+	}
+	if err := UnmarshalString(`{:price 19.90M :tax 5N :amount 0.1}`, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Price.String() != "19.90" || s.Tax.String() != "5" || s.Amount.String() != "0.1" {
+		t.Errorf("Expected decimals to be decoded exactly, got %s %s %s", &s.Price, s.Tax, s.Amount)
+	}
+	bs, err := Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != `{:price 19.90M :tax 5M :amount 0.1M}` {
+		t.Errorf("Expected decimals to encode with M suffix, got %s", bs)
+	}
+	if err := UnmarshalString(`"19.90"`, &s.Price); err == nil {
+		t.Errorf("Expected error when decoding string into Decimal")
+	}
+
+	var val interface{}
+	if err := UnmarshalString(`1.10M`, &val); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := val.(*big.Float); !ok {
+		t.Errorf("Expected *big.Float without decimals, got %T", val)
+	}
+	val = nil
+	d := NewDecoder(strings.NewReader(`[1.10M 1.5]`))
+	d.UseDecimals()
+	if err := d.Decode(&val); err != nil {
+		t.Fatal(err)
+	}
+	vec := val.([]interface{})
+	if dec, ok := vec[0].(*Decimal); !ok || dec.String() != "1.10" {
+		t.Errorf("Expected *Decimal 1.10, got %#v", vec[0])
+	}
+	if vec[1] != 1.5 {
+		t.Errorf("Expected floats without M suffix to be float64, got %#v", vec[1])
+	}
+}
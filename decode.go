@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
 	"reflect"
 	"runtime"
@@ -29,11 +30,13 @@ type UnknownTagError struct {
 	tag    []byte
 	value  []byte
 	inType reflect.Type
+	Position
+	Excerpt string
 }
 
 func (ute UnknownTagError) Error() string {
 	return fmt.Sprintf("Unable to decode %s%s into %s", string(ute.tag),
-		string(ute.value), ute.inType)
+		string(ute.value), ute.inType) + errorContext("", ute.Position, ute.Excerpt)
 }
 
 // Unmarshal parses the EDN-encoded data and stores the result in the value
@@ -63,8 +66,12 @@ func (ute UnknownTagError) Error() string {
 // Unmarshal stores one of these in the interface value:
 //
 //	bool, for EDN booleans
-//	float64, for EDN floats
+//	float64, for EDN floats, including ##Inf, ##-Inf and ##NaN
 //	int64, for EDN integers
+//	big.Int, for EDN integers with the N suffix
+//	*edn.Decimal, for EDN floats with the M suffix if the Decoder uses decimals
+//	edn.Number, for all EDN numbers if the Decoder uses numbers
+//	*big.Rat, for EDN ratios
 //	int32, for EDN characters
 //	string, for EDN strings
 //	[]interface{}, for EDN vectors and lists
@@ -74,6 +81,9 @@ func (ute UnknownTagError) Error() string {
 //	edn.Tag for unknown EDN tagged elements
 //	T for known EDN tagged elements, where T is the result of the converter function
 //
+// Map keys and set elements which cannot be used as Go map keys, such as
+// vectors, maps and big integers, are stored as edn.Values.
+//
 // To unmarshal an EDN vector/list into a slice, Unmarshal resets the slice to
 // nil and then appends each element to the slice.
 //
@@ -85,6 +95,7 @@ func (ute UnknownTagError) Error() string {
 // overflows the target type, Unmarshal skips that field and completes the
 // unmarshalling as best it can. If no more serious errors are encountered,
 // Unmarshal returns an UnmarshalTypeError describing the earliest such error.
+// To get all such errors, use a Decoder with CollectErrors enabled.
 //
 // The EDN nil value unmarshals into an interface, map, pointer, or slice by
 // setting that Go value to nil.
@@ -93,6 +104,9 @@ func (ute UnknownTagError) Error() string {
 // are not treated as an error. Instead, they are replaced by the Unicode
 // replacement character U+FFFD.
 //
+// Metadata in front of a value, as in ^:private or ^{:doc "..."}, is a syntax
+// error. A Decoder can skip or keep metadata instead, see MetadataPolicy.
+//
 func Unmarshal(data []byte, v interface{}) error {
 	return newDecoder(bufio.NewReader(bytes.NewBuffer(data))).Decode(v)
 }
@@ -142,6 +156,62 @@ func (d *Decoder) UseTagMap(tm *TagMap) {
 	d.tagmap = tm
 }
 
+// IsolateTags makes the decoder ignore the global TagMap, so that tag
+// functions added with the global AddTagFn and AddTagStruct have no effect on
+// it. Only tags in the decoder's own TagMap and the built-in tags named in
+// builtins are recognized. The built-in tags are "inst", "base64" and "uuid".
+//
+// IsolateTags returns ErrNotBuiltinTag if builtins contains a name which is
+// not a built-in tag.
+func (d *Decoder) IsolateTags(builtins ...string) error {
+	tags := make(map[string]reflect.Value, len(builtins))
+	builtinTags.RLock()
+	defer builtinTags.RUnlock()
+	for _, name := range builtins {
+		f, ok := builtinTags.m[name]
+		if !ok {
+			return ErrNotBuiltinTag
+		}
+		tags[name] = f
+	}
+	d.isolatedTags = true
+	d.builtinTags = tags
+	return nil
+}
+
+// An UnknownTagPolicy decides how a Decoder decodes tagged values with a tag
+// it does not know.
+type UnknownTagPolicy int
+
+const (
+	// InferUnknownTags decodes unknown tagged values into an edn.Tag when the
+	// destination is an empty interface or an edn.Tag, and returns an
+	// UnknownTagError otherwise. This is the default.
+	InferUnknownTags UnknownTagPolicy = iota
+	// RejectUnknownTags returns an UnknownTagError for all unknown tagged
+	// values, except when the destination is an edn.Tag.
+	RejectUnknownTags
+	// KeepUnknownTags decodes all unknown tagged values into an edn.Tag. It is
+	// an error if the destination cannot hold an edn.Tag.
+	KeepUnknownTags
+)
+
+// UseUnknownTagPolicy sets the policy the decoder uses for unknown tags, and
+// removes any function set by UseUnknownTagFn.
+func (d *Decoder) UseUnknownTagPolicy(policy UnknownTagPolicy) {
+	d.unknownTags = policy
+	d.unknownTagFn = nil
+}
+
+// UseUnknownTagFn makes the decoder call fn for tagged values with unknown
+// tags. The tagged value is decoded as if it was decoded into an empty
+// interface, and passed to fn as an edn.Tag. The result of fn is then used as
+// the decoded value, in the same way as the result of a tag function.
+// Destinations of type edn.Tag are decoded as is, without calling fn.
+func (d *Decoder) UseUnknownTagFn(fn func(Tag) (interface{}, error)) {
+	d.unknownTagFn = fn
+}
+
 // UseMathContext sets the given math context as default math context for this
 // decoder.
 func (d *Decoder) UseMathContext(mc MathContext) {
@@ -162,6 +232,28 @@ func (d *Decoder) DisallowUnknownFields() {
 	d.disallowUnknownFields = true
 }
 
+// UseCollectionTypes causes the Decoder to decode lists, vectors and sets into
+// an interface{} as a List, a Vector and a Set instead of as []interface{} and
+// map[interface{}]bool. This retains the brackets of the input when the value
+// is encoded again.
+func (d *Decoder) UseCollectionTypes() {
+	d.collectionTypes = true
+}
+
+// CollectErrors causes the Decoder to return all errors found while decoding a
+// value, instead of only the first one. Type mismatches, unknown fields (see
+// DisallowUnknownFields), missing required fields and failing tag conversions
+// are returned together as an ErrorList, in the order they occurred. Values
+// which cause such errors are skipped, and the rest of the value is decoded as
+// usual.
+//
+// Syntax errors and other errors that prevent reading the rest of the input
+// still stop decoding immediately. If errors were collected before such an
+// error, it is appended to the ErrorList.
+func (d *Decoder) CollectErrors() {
+	d.collectErrors = true
+}
+
 // Unmarshaler is the interface implemented by objects that can unmarshal an EDN
 // description of themselves. The input can be assumed to be a valid encoding of
 // an EDN value. UnmarshalEDN must copy the EDN data if it wishes to retain the
@@ -185,15 +277,48 @@ const (
 // A Decoder reads and decodes EDN objects from an input stream.
 type Decoder struct {
 	disallowUnknownFields bool
+	collectErrors         bool
+	collectionTypes       bool
+	isolatedTags          bool
+	builtinTags           map[string]reflect.Value
+	unknownTags           UnknownTagPolicy
+	unknownTagFn          func(Tag) (interface{}, error)
+	references            bool
+	decimals              bool
+	useNumber             bool
+	metadata              MetadataPolicy
 
 	lex        *lexer
 	savedError error
 	rd         *bufio.Reader
 	tagmap     *TagMap
+	naming     *NamingStrategy
 	mc         *MathContext
+	// token stack used by Token, More and Skip
+	tokens *tokenStack
+	// position tracking: pos is the position of the next rune, lastPos the
+	// position of the last rune read, and tokenPos the position of the start of
+	// the last token returned by rawToken.
+	pos, lastPos, tokenPos  Position
+	lineBuf, prevLine       []byte
+	lineBufCol, prevLineCol int
+	// path from the top level value to the value currently being decoded
+	path []pathElem
+	// errors which did not stop decoding of the current value
+	errs []error
+	// values tagged with #edn/id in the current value
+	refs map[int64]reflect.Value
+	// metadata of the token last returned by nextToken, if kept
+	meta map[interface{}]interface{}
+	// limits, the current nesting depth and the offset where the current value
+	// started
+	limits     DecoderLimits
+	depth      int
+	bytesStart int64
 	// parser-specific
 	prevSlice []byte
 	prevTtype tokenType
+	prevPos   Position
 	undo      bool
 	// if nextToken returned lexEndPrev, we must write the leftover value at
 	// next call to nextToken
@@ -221,32 +346,91 @@ func (e *InvalidUnmarshalError) Error() string {
 // An UnmarshalTypeError describes a EDN value that was
 // not appropriate for a value of a specific Go type.
 type UnmarshalTypeError struct {
-	Value string       // description of EDN value - "bool", "array", "number -5"
-	Type  reflect.Type // type of Go value it could not be assigned to
+	Value    string       // description of EDN value - "bool", "array", "number -5"
+	Type     reflect.Type // type of Go value it could not be assigned to
+	Path     string       // path to the EDN value, e.g. "[:db :pool 2 :timeout]"
+	Struct   string       // name of the outermost struct type containing the field
+	Field    string       // Go field names from Struct to the field, joined by dots
+	Position              // position of the EDN value in the input
+	Excerpt  string       // the input around the EDN value, if available
 }
 
 func (e *UnmarshalTypeError) Error() string {
-	return "edn: cannot unmarshal " + e.Value + " into Go value of type " + e.Type.String()
+	into := "Go value"
+	switch {
+	case e.Field != "" && e.Struct != "":
+		into = "Go struct field " + e.Struct + "." + e.Field
+	case e.Field != "":
+		into = "Go struct field " + e.Field
+	}
+	return "edn: cannot unmarshal " + e.Value + " into " + into + " of type " + e.Type.String() +
+		errorContext(e.Path, e.Position, e.Excerpt)
 }
 
-// UnhashableError is an error which occurs when the decoder attempted to assign
-// an unhashable key to a map or set. The position close to where value was
-// found is provided to help debugging.
-type UnhashableError struct {
-	Position int64
+// An UnknownFieldError is returned when a Decoder which disallows unknown
+// fields finds a key that does not match any field in the destination struct.
+type UnknownFieldError struct {
+	Field    string       // the field name
+	Type     reflect.Type // type of Go struct with a missing field
+	Path     string       // path to the key, e.g. "[:db :pool 2 :timeout]"
+	Position              // position of the key in the input
+	Excerpt  string       // the input around the key, if available
 }
 
-func (e *UnhashableError) Error() string {
-	return "edn: unhashable type at position " + strconv.FormatInt(e.Position, 10) + " in input"
+func (e *UnknownFieldError) Error() string {
+	return "edn: cannot find a field '" + e.Field + "' in a struct " + e.Type.String() + " to unmarshal into" +
+		errorContext(e.Path, e.Position, e.Excerpt)
 }
 
-type UnknownFieldError struct {
-	Field string       // the field name
-	Type  reflect.Type // type of Go struct with a missing field
+// A MissingFieldError is returned when a map lacks the key of a struct field
+// with the "required" option.
+type MissingFieldError struct {
+	Field    string       // the key of the field, without leading colon
+	Type     reflect.Type // type of the Go struct with the required field
+	Path     string       // path to the map, e.g. "[:db :pool 2]"
+	Position              // position of the map in the input
+	Excerpt  string       // the input around the start of the map, if available
 }
 
-func (e *UnknownFieldError) Error() string {
-	return "edn: cannot find a field '" + e.Field + "' in a struct " + e.Type.String() + " to unmarshal into"
+func (e *MissingFieldError) Error() string {
+	return "edn: missing required field '" + e.Field + "' of struct " + e.Type.String() +
+		errorContext(e.Path, e.Position, e.Excerpt)
+}
+
+// A TagError records an error returned by a tag function, along with where the
+// tagged value was found.
+type TagError struct {
+	Tag      string // the tag name, without the leading '#'
+	Err      error  // the error returned by the tag function
+	Path     string // path to the tagged value, e.g. "[:db :pool 2 :timeout]"
+	Position        // position of the tag in the input
+	Excerpt  string // the input around the tag, if available
+}
+
+func (e *TagError) Error() string {
+	return "edn: #" + e.Tag + ": " + e.Err.Error() + errorContext(e.Path, e.Position, e.Excerpt)
+}
+
+func (e *TagError) Unwrap() error {
+	return e.Err
+}
+
+// An ErrorList is a list of errors returned by a Decoder which collects
+// errors. See Decoder.CollectErrors.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return strconv.Itoa(len(l)) + " errors:\n" + strings.Join(msgs, "\n")
 }
 
 // Decode reads the next EDN-encoded value from its input and stores it in the
@@ -257,23 +441,31 @@ func (e *UnknownFieldError) Error() string {
 func (d *Decoder) Decode(val interface{}) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			// if unhashable, return ErrUnhashable. Else panic unless it's an error
-			// from the decoder itself.
-			if rerr, ok := r.(runtime.Error); ok {
-				if strings.Contains(rerr.Error(), "unhashable") {
-					err = &UnhashableError{Position: d.lex.position}
-				} else {
-					panic(r)
-				}
-			} else {
-				err = r.(error)
+			// panic unless it's an error from the decoder itself.
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			err = r.(error)
+			if d.collectErrors && len(d.errs) > 0 {
+				err = append(ErrorList(d.errs), err)
 			}
 		}
 	}()
 
+	if d.savedError != nil {
+		err = d.savedError
+		d.savedError = nil
+		return err
+	}
+
+	d.path = d.path[:0]
+	d.errs = nil
+	d.refs = nil
+	d.depth = 0
+	d.bytesStart = d.pos.Offset
 	err = d.more()
 	if err != nil {
-		return err
+		return d.positioned(err)
 	}
 
 	rv := reflect.ValueOf(val)
@@ -282,8 +474,16 @@ func (d *Decoder) Decode(val interface{}) (err error) {
 	}
 
 	d.value(rv)
+	d.consumedValue()
 
-	return nil
+	switch {
+	case len(d.errs) == 0:
+		return nil
+	case d.collectErrors:
+		return ErrorList(d.errs)
+	default:
+		return d.errs[0]
+	}
 }
 
 func newDecoder(buf *bufio.Reader) *Decoder {
@@ -295,6 +495,8 @@ func newDecoder(buf *bufio.Reader) *Decoder {
 		hasLeftover: false,
 		leftover:    '\uFFFD',
 		tagmap:      new(TagMap),
+		pos:         Position{Line: 1, Column: 1},
+		lineBufCol:  1,
 	}
 }
 
@@ -305,17 +507,77 @@ func (d *Decoder) getTagFn(tagname string) *reflect.Value {
 	if ok {
 		return &f
 	}
+	if d.isolatedTags {
+		f, ok = d.builtinTags[tagname]
+		if ok {
+			return &f
+		}
+		return nil
+	}
 	globalTags.RLock()
 	f, ok = globalTags.m[tagname]
 	globalTags.RUnlock()
 	if ok {
 		return &f
 	}
+	builtinTags.RLock()
+	f, ok = builtinTags.m[tagname]
+	builtinTags.RUnlock()
+	if ok {
+		return &f
+	}
 	return nil
 }
 
 func (d *Decoder) error(err error) {
-	panic(err)
+	panic(d.positioned(err))
+}
+
+// typeError records an error which does not prevent decoding the rest of the
+// value. The caller must skip the offending value.
+func (d *Decoder) typeError(err error) {
+	d.errs = append(d.errs, d.positioned(err))
+}
+
+// tagError records an error returned by the tag function for tag, found at
+// pos.
+func (d *Decoder) tagError(tag []byte, pos Position, err error) {
+	d.typeError(&TagError{
+		Tag:      string(tag[1:]),
+		Err:      err,
+		Path:     d.pathString(),
+		Position: pos,
+		Excerpt:  d.excerpt(pos),
+	})
+}
+
+// skipRest skips the remaining elements of a collection started with start,
+// including its end delimiter.
+func (d *Decoder) skipRest(start tokenType) {
+	tstack := newTokenStack()
+	tstack.push(start)
+	for !tstack.done() {
+		_, tt, err := d.nextToken()
+		if err == nil {
+			err = tstack.push(tt)
+		}
+		if err != nil {
+			d.error(err)
+		}
+	}
+}
+
+// startType returns the start token of collections ending with end. Sets and
+// maps end with the same token, so endType must not be tokenMapEnd.
+func startType(end tokenType) tokenType {
+	switch end {
+	case tokenListEnd:
+		return tokenListStart
+	case tokenVectorEnd:
+		return tokenVectorStart
+	default:
+		return tokenSetStart
+	}
 }
 
 func (d *Decoder) doUndo(bs []byte, ttype tokenType) {
@@ -325,6 +587,7 @@ func (d *Decoder) doUndo(bs []byte, ttype tokenType) {
 	d.undo = true
 	d.prevSlice = bs
 	d.prevTtype = ttype
+	d.prevPos = d.tokenPos
 }
 
 // array consumes an array from d.data[d.off-1:], decoding into the value v.
@@ -363,7 +626,8 @@ func (d *Decoder) array(v reflect.Value, endType tokenType) {
 		// Otherwise it's invalid.
 		fallthrough
 	default:
-		d.error(&UnmarshalTypeError{"array", v.Type()})
+		d.typeError(&UnmarshalTypeError{Value: "array", Type: v.Type()})
+		d.skipRest(startType(endType))
 		return
 	case reflect.Array:
 	case reflect.Slice:
@@ -399,6 +663,8 @@ func (d *Decoder) array(v reflect.Value, endType tokenType) {
 			}
 		}
 
+		d.checkElements(i + 1)
+		d.pushIndex(i)
 		if i < v.Len() {
 			// Decode into element.
 			d.value(v.Index(i))
@@ -406,6 +672,7 @@ func (d *Decoder) array(v reflect.Value, endType tokenType) {
 			// Ran out of fixed array: skip.
 			d.value(reflect.Value{})
 		}
+		d.popPath()
 		i++
 	}
 
@@ -438,7 +705,16 @@ func (d *Decoder) arrayInterface(endType tokenType) interface{} {
 			break
 		}
 		d.doUndo(bs, tt)
+		d.checkElements(len(v) + 1)
+		d.pushIndex(len(v))
 		v = append(v, d.valueInterface())
+		d.popPath()
+	}
+	if d.collectionTypes {
+		if endType == tokenListEnd {
+			return List(v)
+		}
+		return Vector(v)
 	}
 	return v
 }
@@ -456,27 +732,52 @@ func (d *Decoder) value(v reflect.Value) {
 		d.error(err)
 		return
 	}
+	if (d.meta != nil || isWithMeta(v.Type())) && d.valueMeta(bs, ttype, v) {
+		return
+	}
 	switch ttype {
 	default:
 		d.error(errUnexpected)
-	case tokenSymbol, tokenKeyword, tokenString, tokenInt, tokenFloat, tokenChar:
+	case tokenSymbol, tokenKeyword, tokenString, tokenInt, tokenFloat, tokenRatio, tokenChar:
 		d.literal(bs, ttype, v)
 	case tokenTag:
-		d.tag(bs, v)
+		d.enter()
+		if d.references && referenceTag(bs) {
+			d.reference(bs, v)
+		} else {
+			d.tag(bs, v)
+		}
+		d.depth--
 	case tokenListStart:
+		d.enter()
 		d.array(v, tokenListEnd)
+		d.depth--
 	case tokenVectorStart:
+		d.enter()
 		d.array(v, tokenVectorEnd)
+		d.depth--
 	case tokenSetStart:
+		d.enter()
 		d.set(v)
+		d.depth--
 	case tokenMapStart:
+		d.enter()
 		d.ednmap(v)
+		d.depth--
 	}
 }
 
 func (d *Decoder) tag(tag []byte, v reflect.Value) {
+	pos := d.tokenPos
 	// Check for unmarshaler.
 	u, pv := d.indirect(v, false)
+	if t, ok := u.(*Tag); ok {
+		// decode tags in place, so that the value is decoded with this decoder's
+		// tags and settings
+		t.Tagname = string(tag[1:])
+		t.Value = d.valueInterface()
+		return
+	}
 	if u != nil {
 		bs, err := d.nextValueBytes()
 		if err == nil {
@@ -490,22 +791,41 @@ func (d *Decoder) tag(tag []byte, v reflect.Value) {
 	v = pv
 
 	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
-		v.Set(reflect.ValueOf(d.tagInterface(tag)))
+		// tagInterface returns nil if the tagged value is nil or is an error
+		if res := d.tagInterface(tag); res != nil {
+			v.Set(reflect.ValueOf(res))
+		} else {
+			v.Set(reflect.Zero(v.Type()))
+		}
 		return
 	}
 
 	fn := d.getTagFn(string(tag[1:]))
 	if fn == nil {
-		// So in theory we'd have to match against any interface that could be
-		// assignable to the Tag type, to ensure we would decode whenever possible.
-		// That is any interface that specifies any combination of the methods
-		// MarshalEDN, UnmarshalEDN and String. I'm not sure if that makes sense
-		// though, so I've punted this for now.
-		bs, err := d.nextValueBytes()
-		if err != nil {
-			d.error(err)
+		switch {
+		case d.unknownTagFn != nil:
+			t := Tag{Tagname: string(tag[1:]), Value: d.valueInterface()}
+			res, err := d.unknownTagFn(t)
+			if err != nil {
+				d.tagError(tag, pos, err)
+				return
+			}
+			d.assignTagResult(tag, pos, reflect.ValueOf(res), v)
+		case d.unknownTags == KeepUnknownTags:
+			t := Tag{Tagname: string(tag[1:]), Value: d.valueInterface()}
+			d.assignTagResult(tag, pos, reflect.ValueOf(t), v)
+		default:
+			// So in theory we'd have to match against any interface that could be
+			// assignable to the Tag type, to ensure we would decode whenever possible.
+			// That is any interface that specifies any combination of the methods
+			// MarshalEDN, UnmarshalEDN and String. I'm not sure if that makes sense
+			// though, so I've punted this for now.
+			bs, err := d.nextValueBytes()
+			if err != nil {
+				d.error(err)
+			}
+			d.typeError(UnknownTagError{tag: tag, value: bs, inType: v.Type(), Position: pos})
 		}
-		d.error(UnknownTagError{tag, bs, v.Type()})
 	} else {
 		tfn := fn.Type()
 		var result reflect.Value
@@ -515,40 +835,73 @@ func (d *Decoder) tag(tag []byte, v reflect.Value) {
 			d.value(result)
 		} else { // otherwise match on input value and call the function
 			inVal := reflect.New(tfn.In(0))
+			nerrs := len(d.errs)
 			d.value(inVal)
+			if len(d.errs) > nerrs {
+				return
+			}
 			res := fn.Call([]reflect.Value{inVal.Elem()})
 			if err, ok := res[1].Interface().(error); ok && err != nil {
-				d.error(err)
+				d.tagError(tag, pos, err)
+				return
 			}
 			result = res[0]
 		}
-		// result is not necessarily direct, so we have to make it direct, but
-		// *only* if it's NOT null at every step. Which leads to the question: How
-		// do we unify these values? This is particularly hairy if these are double
-		// pointers or bigger.
-
-		// Currently we only attempt to solve this for results by checking if the
-		// result can be dereferenced into a value. The value will always be a
-		// non-pointer, so presumably we can assign it in this fashion as a
-		// temporary resolution.
-		if result.Type().AssignableTo(v.Type()) {
-			v.Set(result)
-			return
-		}
-		if result.Kind() == reflect.Ptr && !result.IsNil() &&
-			result.Elem().Type().AssignableTo(v.Type()) {
-			// is res a non-nil pointer to a value we can assign to? If yes, then
-			// let's just do that.
-			v.Set(result.Elem())
-			return
-		}
-		d.error(fmt.Errorf("Cannot assign %s to %s (tag issue?)", result.Type(), v.Type()))
+		d.assignTagResult(tag, pos, result, v)
 	}
 }
 
+// assignTagResult assigns result, the result of converting the tagged value
+// tag found at pos, to v.
+func (d *Decoder) assignTagResult(tag []byte, pos Position, result, v reflect.Value) {
+	if !result.IsValid() { // nil from an unknown tag function
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	// result is not necessarily direct, so we have to make it direct, but
+	// *only* if it's NOT null at every step. Which leads to the question: How
+	// do we unify these values? This is particularly hairy if these are double
+	// pointers or bigger.
+
+	// Currently we only attempt to solve this for results by checking if the
+	// result can be dereferenced into a value. The value will always be a
+	// non-pointer, so presumably we can assign it in this fashion as a
+	// temporary resolution.
+	if result.Type().AssignableTo(v.Type()) {
+		v.Set(result)
+		return
+	}
+	if result.Kind() == reflect.Ptr && !result.IsNil() &&
+		result.Elem().Type().AssignableTo(v.Type()) {
+		// is res a non-nil pointer to a value we can assign to? If yes, then
+		// let's just do that.
+		v.Set(result.Elem())
+		return
+	}
+	d.tagError(tag, pos, fmt.Errorf("Cannot assign %s to %s (tag issue?)", result.Type(), v.Type()))
+}
+
 func (d *Decoder) tagInterface(tag []byte) interface{} {
+	pos := d.tokenPos
 	fn := d.getTagFn(string(tag[1:]))
 	if fn == nil {
+		switch {
+		case d.unknownTagFn != nil:
+			t := Tag{Tagname: string(tag[1:]), Value: d.valueInterface()}
+			res, err := d.unknownTagFn(t)
+			if err != nil {
+				d.tagError(tag, pos, err)
+				return nil
+			}
+			return res
+		case d.unknownTags == RejectUnknownTags:
+			bs, err := d.nextValueBytes()
+			if err != nil {
+				d.error(err)
+			}
+			d.typeError(UnknownTagError{tag: tag, value: bs, inType: emptyInterfaceType, Position: pos})
+			return nil
+		}
 		var t Tag
 		t.Tagname = string(tag[1:])
 		t.Value = d.valueInterface()
@@ -560,10 +913,15 @@ func (d *Decoder) tagInterface(tag []byte) interface{} {
 	} else {
 		tfn := fn.Type()
 		val := reflect.New(tfn.In(0))
+		nerrs := len(d.errs)
 		d.value(val)
+		if len(d.errs) > nerrs {
+			return nil
+		}
 		res := fn.Call([]reflect.Value{val.Elem()})
 		if err, ok := res[1].Interface().(error); ok && err != nil {
-			d.error(err)
+			d.tagError(tag, pos, err)
+			return nil
 		}
 		return res[0].Interface()
 	}
@@ -576,24 +934,42 @@ func (d *Decoder) valueInterface() interface{} {
 		d.error(err)
 		return nil /// won't get here
 	}
+	if d.meta != nil {
+		meta := d.meta
+		d.meta = nil
+		if ttype != tokenSymbol || !bytes.Equal(bs, nilByte) {
+			d.doUndo(bs, ttype)
+			return WithMeta{Meta: meta, Value: d.valueInterface()}
+		}
+	}
 	switch ttype {
+	case tokenSymbol, tokenKeyword, tokenString, tokenInt, tokenFloat, tokenRatio, tokenChar:
+		return d.literalInterface(bs, ttype)
+	case tokenTag, tokenListStart, tokenVectorStart, tokenSetStart, tokenMapStart:
 	default:
 		d.error(errUnexpected)
 		return nil
-	case tokenSymbol, tokenKeyword, tokenString, tokenInt, tokenFloat, tokenChar:
-		return d.literalInterface(bs, ttype)
+	}
+	var v interface{}
+	d.enter()
+	switch ttype {
 	case tokenTag:
-		return d.tagInterface(bs)
+		if d.references && referenceTag(bs) {
+			v = d.referenceInterface(bs, d.referenceID())
+		} else {
+			v = d.tagInterface(bs)
+		}
 	case tokenListStart:
-		return d.arrayInterface(tokenListEnd)
+		v = d.arrayInterface(tokenListEnd)
 	case tokenVectorStart:
-		return d.arrayInterface(tokenVectorEnd)
+		v = d.arrayInterface(tokenVectorEnd)
 	case tokenSetStart:
-		return d.setInterface()
+		v = d.setInterface()
 	case tokenMapStart:
-		return d.ednmapInterface()
+		v = d.ednmapInterface()
 	}
-	return nil
+	d.depth--
+	return v
 }
 
 func (d *Decoder) ednmap(v reflect.Value) {
@@ -630,11 +1006,29 @@ func (d *Decoder) ednmap(v reflect.Value) {
 	case reflect.Struct:
 
 	default:
-		d.error(&UnmarshalTypeError{"map", v.Type()})
+		d.typeError(&UnmarshalTypeError{Value: "map", Type: v.Type()})
+		d.skipRest(tokenMapStart)
+		return
 	}
 
 	// separate these to ease reading (theoretically fewer checks too)
 	if v.Kind() == reflect.Struct {
+		mapPos := d.tokenPos
+		fields := cachedTypeFields(v.Type(), d.naming)
+		var seen []bool // only tracked if a field is required or has a default
+		var rest *field
+		for i := range fields {
+			if fields[i].rest {
+				rest = &fields[i]
+				if err := checkRestField(v.Type(), rest); err != nil {
+					d.error(err)
+				}
+			}
+			if seen == nil && (fields[i].required || fields[i].defaultValue != nil) {
+				seen = make([]bool, len(fields))
+			}
+		}
+		n := 0
 		for {
 			bs, tt, err := d.nextToken()
 			if err != nil {
@@ -643,6 +1037,8 @@ func (d *Decoder) ednmap(v reflect.Value) {
 			if tt == tokenSetEnd {
 				break
 			}
+			n++
+			d.checkElements(n)
 			skip := false
 			var key []byte
 			// The key can either be a symbol, a keyword or a string. We will skip
@@ -665,45 +1061,61 @@ func (d *Decoder) ednmap(v reflect.Value) {
 				skip = true
 			}
 
+			if skip && rest != nil {
+				d.doUndo(bs, tt)
+				d.restEntry(v, rest, bs, tt)
+				continue
+			}
 			if skip { // will panic if something bad happens, so this is fine
 				d.valueInterface()
 				continue
 			}
 
 			var subv reflect.Value
+			var fieldName string
 			var f *field
-			fields := cachedTypeFields(v.Type())
+			fi := -1
 			for i := range fields {
 				ff := &fields[i]
+				if ff.rest || ff.meta {
+					continue
+				}
 				if bytes.Equal(ff.nameBytes, key) {
-					f = ff
+					f, fi = ff, i
 					break
 				}
 				if f == nil && ff.equalFold(ff.nameBytes, key) {
-					f = ff
+					f, fi = ff, i
 				}
 			}
 			if f != nil {
-				subv = v
-				for _, i := range f.index {
-					if subv.Kind() == reflect.Ptr {
-						if subv.IsNil() {
-							subv.Set(reflect.New(subv.Type().Elem()))
-						}
-						subv = subv.Elem()
-					}
-					subv = subv.Field(i)
+				subv, fieldName = allocFieldByIndex(v, f.index)
+				if seen != nil {
+					seen[fi] = true
 				}
-			} else if d.disallowUnknownFields {
-				d.error(&UnknownFieldError{string(key), v.Type()})
+			} else if rest != nil {
+				d.doUndo(bs, tt)
+				d.restEntry(v, rest, bs, tt)
+				continue
+			}
+			d.pushField(bs, v.Type(), fieldName)
+			if f == nil && d.disallowUnknownFields {
+				d.typeError(&UnknownFieldError{Field: string(key), Type: v.Type()})
 			}
 			// If subv not set, value() will just skip.
 			d.value(subv)
+			d.popPath()
+		}
+		for i, ok := range seen {
+			if !ok && (fields[i].required || fields[i].defaultValue != nil) {
+				d.missingField(v, &fields[i], mapPos)
+			}
 		}
 		// if not struct, then it is a map
 	} else if keyType.Kind() == reflect.Interface && keyType.NumMethod() == 0 {
-		// special case for unhashable key types
+		// special case for unhashable key types, which are stored as Values
 		var mapElem reflect.Value
+		n := 0
 		for {
 			bs, tt, err := d.nextToken()
 			if err != nil {
@@ -712,7 +1124,10 @@ func (d *Decoder) ednmap(v reflect.Value) {
 			if tt == tokenSetEnd {
 				break
 			}
+			n++
+			d.checkElements(n)
 			d.doUndo(bs, tt)
+			d.pushKey(bs, tt)
 
 			key := d.valueInterface()
 			elemType := v.Type().Elem()
@@ -727,16 +1142,13 @@ func (d *Decoder) ednmap(v reflect.Value) {
 			if key == nil {
 				v.SetMapIndex(reflect.New(keyType).Elem(), subv)
 			} else {
-				switch reflect.TypeOf(key).Kind() {
-				case reflect.Slice, reflect.Map: // bypass issues with unhashable types
-					v.SetMapIndex(reflect.ValueOf(&key), subv)
-				default:
-					v.SetMapIndex(reflect.ValueOf(key), subv)
-				}
+				v.SetMapIndex(reflect.ValueOf(d.hashKey(key)), subv)
 			}
+			d.popPath()
 		}
 	} else { // default map case
 		var mapElem reflect.Value
+		n := 0
 		for {
 			bs, tt, err := d.nextToken()
 			if err != nil {
@@ -745,11 +1157,16 @@ func (d *Decoder) ednmap(v reflect.Value) {
 			if tt == tokenSetEnd {
 				break
 			}
+			n++
+			d.checkElements(n)
 			d.doUndo(bs, tt)
+			d.pushKey(bs, tt)
 
 			// should we do the same as with mapElem?
 			key := reflect.New(keyType).Elem()
+			nerrs := len(d.errs)
 			d.value(key)
+			keyOk := len(d.errs) == nerrs
 
 			elemType := v.Type().Elem()
 			if !mapElem.IsValid() {
@@ -759,13 +1176,108 @@ func (d *Decoder) ednmap(v reflect.Value) {
 			}
 			subv := mapElem
 			d.value(subv)
-			v.SetMapIndex(key, subv)
+			if keyOk {
+				v.SetMapIndex(key, subv)
+			}
+			d.popPath()
 		}
 	}
 }
 
+// allocFieldByIndex returns the field of the struct v with the given index
+// sequence and the name of the Go field, allocating embedded struct pointers as
+// necessary.
+func allocFieldByIndex(v reflect.Value, index []int) (reflect.Value, string) {
+	var name string
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		name = v.Type().Field(i).Name
+		v = v.Field(i)
+	}
+	return v, name
+}
+
+// missingField handles the field f of the struct v, which is absent from the
+// map starting at mapPos. Required fields are reported as missing, and fields
+// with a default value are set to it.
+func (d *Decoder) missingField(v reflect.Value, f *field, mapPos Position) {
+	if f.required {
+		d.typeError(&MissingFieldError{
+			Field:    f.name,
+			Type:     v.Type(),
+			Path:     d.pathString(),
+			Position: mapPos,
+			Excerpt:  d.excerpt(mapPos),
+		})
+		return
+	}
+	subv, fieldName := allocFieldByIndex(v, f.index)
+	sub := d.withInput(f.defaultValue)
+	if err := sub.Decode(subv.Addr().Interface()); err != nil {
+		d.error(fmt.Errorf("edn: invalid default value for field %s of %s: %v", fieldName, v.Type(), err))
+	}
+}
+
+// checkRestField returns an error if the rest field f of the struct type t is
+// not a map.
+func checkRestField(t reflect.Type, f *field) error {
+	if f.typ.Kind() != reflect.Map {
+		return fmt.Errorf("edn: rest field %s of %s must be a map, not %s", t.FieldByIndex(f.index).Name, t, f.typ)
+	}
+	return nil
+}
+
+// restEntry decodes the next map entry, starting with the token bs, into the
+// rest field f of the struct v.
+func (d *Decoder) restEntry(v reflect.Value, f *field, bs []byte, tt tokenType) {
+	m, _ := allocFieldByIndex(v, f.index)
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(m.Type()))
+	}
+	d.pushKey(bs, tt)
+	defer d.popPath()
+	key := reflect.New(m.Type().Key()).Elem()
+	nerrs := len(d.errs)
+	d.value(key)
+	keyOk := len(d.errs) == nerrs
+	elem := reflect.New(m.Type().Elem()).Elem()
+	d.value(elem)
+	if !keyOk {
+		return
+	}
+	if key.Kind() == reflect.Interface && !key.IsNil() {
+		key = reflect.ValueOf(d.hashKey(key.Interface()))
+	}
+	m.SetMapIndex(key, elem)
+}
+
+// withInput returns a new decoder reading bs with the same options as d.
+func (d *Decoder) withInput(bs []byte) *Decoder {
+	sub := newDecoder(bufio.NewReader(bytes.NewReader(bs)))
+	sub.disallowUnknownFields = d.disallowUnknownFields
+	sub.collectionTypes = d.collectionTypes
+	sub.isolatedTags = d.isolatedTags
+	sub.builtinTags = d.builtinTags
+	sub.unknownTags = d.unknownTags
+	sub.unknownTagFn = d.unknownTagFn
+	sub.tagmap = d.tagmap
+	sub.naming = d.naming
+	sub.references = d.references
+	sub.decimals = d.decimals
+	sub.useNumber = d.useNumber
+	sub.metadata = d.metadata
+	sub.mc = d.mc
+	return sub
+}
+
 func (d *Decoder) ednmapInterface() interface{} {
 	theMap := make(map[interface{}]interface{}, 0)
+	n := 0
 	for {
 		bs, tt, err := d.nextToken()
 		if err != nil {
@@ -774,20 +1286,14 @@ func (d *Decoder) ednmapInterface() interface{} {
 		if tt == tokenMapEnd {
 			break
 		}
+		n++
+		d.checkElements(n)
 		d.doUndo(bs, tt)
+		d.pushKey(bs, tt)
 		key := d.valueInterface()
 		value := d.valueInterface()
-		// special case on nil here. nil is hashable, so use it as key.
-		if key == nil {
-			theMap[key] = value
-		} else {
-			switch reflect.TypeOf(key).Kind() {
-			case reflect.Slice, reflect.Map: // bypass issues with unhashable types
-				theMap[&key] = value
-			default:
-				theMap[key] = value
-			}
-		}
+		d.popPath()
+		theMap[d.hashKey(key)] = value
 	}
 	return theMap
 }
@@ -826,11 +1332,15 @@ func (d *Decoder) set(v reflect.Value) {
 		case reflect.Struct:
 			// check if struct, and if so, ensure it has 0 fields
 			if t.Elem().NumField() != 0 {
-				d.error(&UnmarshalTypeError{"set", v.Type()})
+				d.typeError(&UnmarshalTypeError{Value: "set", Type: v.Type()})
+				d.skipRest(tokenSetStart)
+				return
 			}
 			setValue = reflect.Zero(t.Elem())
 		default:
-			d.error(&UnmarshalTypeError{"set", v.Type()})
+			d.typeError(&UnmarshalTypeError{Value: "set", Type: v.Type()})
+			d.skipRest(tokenSetStart)
+			return
 		}
 		if v.IsNil() {
 			v.Set(reflect.MakeMap(t))
@@ -845,17 +1355,21 @@ func (d *Decoder) set(v reflect.Value) {
 			// break out and use setInterface
 			v.Set(reflect.ValueOf(d.setInterface()))
 			return
-		} else {
-			d.error(&UnmarshalTypeError{"set", v.Type()})
 		}
+		d.typeError(&UnmarshalTypeError{Value: "set", Type: v.Type()})
+		d.skipRest(tokenSetStart)
+		return
 
 	default:
-		d.error(&UnmarshalTypeError{"set", v.Type()})
+		d.typeError(&UnmarshalTypeError{Value: "set", Type: v.Type()})
+		d.skipRest(tokenSetStart)
+		return
 	}
 
-	// special case here, to avoid panics when we have slices and maps as keys.
+	// special case here, to store slices and maps as Values instead of panicking.
 	// Split out from code below to improve perf
 	if keyType.Kind() == reflect.Interface && keyType.NumMethod() == 0 {
+		n := 0
 		for {
 			bs, tt, err := d.nextToken()
 			if err != nil {
@@ -864,22 +1378,22 @@ func (d *Decoder) set(v reflect.Value) {
 			if tt == tokenSetEnd {
 				break
 			}
+			n++
+			d.checkElements(n)
 			d.doUndo(bs, tt)
+			d.pushKey(bs, tt)
 			key := d.valueInterface()
+			d.popPath()
 			// special case on nil here: Need to create a zero type of the specific
 			// keyType. As this is an interface, this will itself be nil.
 			if key == nil {
 				v.SetMapIndex(reflect.New(keyType).Elem(), setValue)
 			} else {
-				switch reflect.TypeOf(key).Kind() {
-				case reflect.Slice, reflect.Map: // bypass issues with unhashable types
-					v.SetMapIndex(reflect.ValueOf(&key), setValue)
-				default:
-					v.SetMapIndex(reflect.ValueOf(key), setValue)
-				}
+				v.SetMapIndex(reflect.ValueOf(d.hashKey(key)), setValue)
 			}
 		}
 	} else {
+		n := 0
 		for {
 			bs, tt, err := d.nextToken()
 			if err != nil {
@@ -888,11 +1402,18 @@ func (d *Decoder) set(v reflect.Value) {
 			if tt == tokenSetEnd {
 				break
 			}
+			n++
+			d.checkElements(n)
 			d.doUndo(bs, tt)
 
 			key := reflect.New(keyType).Elem()
+			d.pushKey(bs, tt)
+			nerrs := len(d.errs)
 			d.value(key)
-			v.SetMapIndex(key, setValue)
+			d.popPath()
+			if len(d.errs) == nerrs {
+				v.SetMapIndex(key, setValue)
+			}
 		}
 	}
 
@@ -900,6 +1421,7 @@ func (d *Decoder) set(v reflect.Value) {
 
 func (d *Decoder) setInterface() interface{} {
 	theSet := make(map[interface{}]bool, 0)
+	n := 0
 	for {
 		bs, tt, err := d.nextToken()
 		if err != nil {
@@ -908,18 +1430,16 @@ func (d *Decoder) setInterface() interface{} {
 		if tt == tokenSetEnd {
 			break
 		}
+		n++
+		d.checkElements(n)
 		d.doUndo(bs, tt)
+		d.pushKey(bs, tt)
 		key := d.valueInterface()
-		if key == nil {
-			theSet[key] = true
-		} else {
-			switch reflect.TypeOf(key).Kind() {
-			case reflect.Slice, reflect.Map: // bypass issues with unhashable types
-				theSet[&key] = true
-			default:
-				theSet[key] = true
-			}
-		}
+		d.popPath()
+		theSet[d.hashKey(key)] = true
+	}
+	if d.collectionTypes {
+		return Set(theSet)
 	}
 	return theSet
 }
@@ -931,9 +1451,13 @@ var falseByte = []byte(`false`)
 var symbolType = reflect.TypeOf(Symbol(""))
 var keywordType = reflect.TypeOf(Keyword(""))
 var byteSliceType = reflect.TypeOf([]byte(nil))
+var emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+var listType = reflect.TypeOf(List(nil))
+var setType = reflect.TypeOf(Set(nil))
 
 var bigFloatType = reflect.TypeOf((*big.Float)(nil)).Elem()
 var bigIntType = reflect.TypeOf((*big.Int)(nil)).Elem()
+var bigRatType = reflect.TypeOf((*big.Rat)(nil)).Elem()
 
 func (d *Decoder) literal(bs []byte, ttype tokenType, v reflect.Value) {
 	wantptr := ttype == tokenSymbol && bytes.Equal(nilByte, bs)
@@ -946,6 +1470,16 @@ func (d *Decoder) literal(bs []byte, ttype tokenType, v reflect.Value) {
 		return
 	}
 	v = pv
+	if isNumberToken(ttype) {
+		switch {
+		case v.Type() == numberType:
+			v.SetString(string(bs))
+			return
+		case d.useNumber && v.Kind() == reflect.Interface && v.NumMethod() == 0:
+			v.Set(reflect.ValueOf(Number(bs)))
+			return
+		}
+	}
 	switch ttype {
 	case tokenSymbol:
 		if wantptr { // nil
@@ -953,20 +1487,23 @@ func (d *Decoder) literal(bs []byte, ttype tokenType, v reflect.Value) {
 			case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
 				v.Set(reflect.Zero(v.Type()))
 			default:
-				d.error(&UnmarshalTypeError{"nil", v.Type()})
+				d.typeError(&UnmarshalTypeError{Value: "nil", Type: v.Type()})
+				return
 			}
 		} else if bytes.Equal(trueByte, bs) || bytes.Equal(falseByte, bs) { // true|false
 			value := bs[0] == 't'
 			switch v.Kind() {
 			default:
-				d.error(&UnmarshalTypeError{"bool", v.Type()})
+				d.typeError(&UnmarshalTypeError{Value: "bool", Type: v.Type()})
+				return
 			case reflect.Bool:
 				v.SetBool(value)
 			case reflect.Interface:
 				if v.NumMethod() == 0 {
 					v.Set(reflect.ValueOf(value))
 				} else {
-					d.error(&UnmarshalTypeError{"bool", v.Type()})
+					d.typeError(&UnmarshalTypeError{Value: "bool", Type: v.Type()})
+					return
 				}
 			}
 		} else if v.Kind() == reflect.String && v.Type() == symbolType { // "actual" symbols
@@ -974,7 +1511,8 @@ func (d *Decoder) literal(bs []byte, ttype tokenType, v reflect.Value) {
 		} else if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
 			v.Set(reflect.ValueOf(Symbol(string(bs))))
 		} else {
-			d.error(&UnmarshalTypeError{"symbol", v.Type()})
+			d.typeError(&UnmarshalTypeError{Value: "symbol", Type: v.Type()})
+			return
 		}
 	case tokenKeyword:
 		if v.Kind() == reflect.String && v.Type() == keywordType { // "actual" keywords
@@ -982,7 +1520,8 @@ func (d *Decoder) literal(bs []byte, ttype tokenType, v reflect.Value) {
 		} else if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
 			v.Set(reflect.ValueOf(Keyword(string(bs[1:]))))
 		} else {
-			d.error(&UnmarshalTypeError{"keyword", v.Type()})
+			d.typeError(&UnmarshalTypeError{Value: "keyword", Type: v.Type()})
+			return
 		}
 	case tokenInt:
 		var s string
@@ -1013,16 +1552,19 @@ func (d *Decoder) literal(bs []byte, ttype tokenType, v reflect.Value) {
 					d.error(errInternal)
 				}
 			default:
-				d.error(&UnmarshalTypeError{"int", v.Type()})
+				d.typeError(&UnmarshalTypeError{Value: "int", Type: v.Type()})
+				return
 			}
 		case reflect.Interface:
 			if !isBig {
 				n, err := strconv.ParseInt(s, 10, 64)
 				if err != nil {
-					d.error(&UnmarshalTypeError{"int " + s, reflect.TypeOf(int64(0))})
+					d.typeError(&UnmarshalTypeError{Value: "int " + s, Type: reflect.TypeOf(int64(0))})
+					return
 				}
 				if v.NumMethod() != 0 {
-					d.error(&UnmarshalTypeError{"int", v.Type()})
+					d.typeError(&UnmarshalTypeError{Value: "int", Type: v.Type()})
+					return
 				}
 				v.Set(reflect.ValueOf(n))
 			} else {
@@ -1036,38 +1578,39 @@ func (d *Decoder) literal(bs []byte, ttype tokenType, v reflect.Value) {
 		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 			n, err := strconv.ParseInt(s, 10, 64)
 			if err != nil || v.OverflowInt(n) {
-				d.error(&UnmarshalTypeError{"int " + s, v.Type()})
+				d.typeError(&UnmarshalTypeError{Value: "int " + s, Type: v.Type()})
+				return
 			}
 			v.SetInt(n)
 
 		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 			n, err := strconv.ParseUint(s, 10, 64)
 			if err != nil || v.OverflowUint(n) {
-				d.error(&UnmarshalTypeError{"int " + s, v.Type()})
+				d.typeError(&UnmarshalTypeError{Value: "int " + s, Type: v.Type()})
+				return
 			}
 			v.SetUint(n)
 
 		case reflect.Float32, reflect.Float64:
 			n, err := strconv.ParseFloat(s, v.Type().Bits())
 			if err != nil || v.OverflowFloat(n) {
-				d.error(&UnmarshalTypeError{"int " + s, v.Type()})
+				d.typeError(&UnmarshalTypeError{Value: "int " + s, Type: v.Type()})
+				return
 			}
 			v.SetFloat(n)
 		}
 
 	case tokenFloat:
-		var s string
-		isBig := false
-		if bs[len(bs)-1] == 'M' { // can end with M, which we promptly ignore
-			s = string(bs[:len(bs)-1])
-			isBig = true
-		} else {
-			s = string(bs)
-		}
+		s := floatString(bs)
+		isBig := bs[len(bs)-1] == 'M'
 		switch v.Kind() {
 		default:
 			switch v.Type() {
 			case bigFloatType:
+				if s == "NaN" {
+					d.typeError(&UnmarshalTypeError{Value: "float " + s, Type: v.Type()})
+					return
+				}
 				mc := d.mathContext()
 				bf := v.Addr().Interface().(*big.Float)
 				bf = bf.SetPrec(mc.Precision).SetMode(mc.Mode)
@@ -1076,18 +1619,27 @@ func (d *Decoder) literal(bs []byte, ttype tokenType, v reflect.Value) {
 					d.error(errInternal)
 				}
 			default:
-				d.error(&UnmarshalTypeError{"float", v.Type()})
+				d.typeError(&UnmarshalTypeError{Value: "float", Type: v.Type()})
+				return
 			}
 		case reflect.Interface:
 			if !isBig {
 				n, err := strconv.ParseFloat(s, 64)
 				if err != nil {
-					d.error(&UnmarshalTypeError{"float " + s, reflect.TypeOf(float64(0))})
+					d.typeError(&UnmarshalTypeError{Value: "float " + s, Type: reflect.TypeOf(float64(0))})
+					return
 				}
 				if v.NumMethod() != 0 {
-					d.error(&UnmarshalTypeError{"float", v.Type()})
+					d.typeError(&UnmarshalTypeError{Value: "float", Type: v.Type()})
+					return
 				}
 				v.Set(reflect.ValueOf(n))
+			} else if d.decimals {
+				if v.NumMethod() != 0 {
+					d.typeError(&UnmarshalTypeError{Value: "float", Type: v.Type()})
+					return
+				}
+				v.Set(reflect.ValueOf(d.decimal(bs)))
 			} else {
 				mc := d.mathContext()
 				bf := new(big.Float).SetPrec(mc.Precision).SetMode(mc.Mode)
@@ -1100,7 +1652,44 @@ func (d *Decoder) literal(bs []byte, ttype tokenType, v reflect.Value) {
 		case reflect.Float32, reflect.Float64:
 			n, err := strconv.ParseFloat(s, v.Type().Bits())
 			if err != nil || v.OverflowFloat(n) {
-				d.error(&UnmarshalTypeError{"float " + s, v.Type()})
+				d.typeError(&UnmarshalTypeError{Value: "float " + s, Type: v.Type()})
+				return
+			}
+			v.SetFloat(n)
+		}
+	case tokenRatio:
+		r := d.ratio(bs)
+		switch v.Kind() {
+		default:
+			switch v.Type() {
+			case bigRatType:
+				v.Addr().Interface().(*big.Rat).Set(r)
+			case bigFloatType:
+				mc := d.mathContext()
+				bf := v.Addr().Interface().(*big.Float)
+				bf.SetPrec(mc.Precision).SetMode(mc.Mode).SetRat(r)
+			default:
+				d.typeError(&UnmarshalTypeError{Value: "ratio", Type: v.Type()})
+				return
+			}
+		case reflect.Interface:
+			if v.NumMethod() != 0 {
+				d.typeError(&UnmarshalTypeError{Value: "ratio", Type: v.Type()})
+				return
+			}
+			v.Set(reflect.ValueOf(r))
+		case reflect.Float32, reflect.Float64:
+			// round to the precision of the float type, using the rounding mode
+			// of the math context
+			prec := uint(53)
+			if v.Kind() == reflect.Float32 {
+				prec = 24
+			}
+			bf := new(big.Float).SetPrec(prec).SetMode(d.mathContext().Mode).SetRat(r)
+			n, _ := bf.Float64()
+			if math.IsInf(n, 0) || v.OverflowFloat(n) {
+				d.typeError(&UnmarshalTypeError{Value: "ratio " + string(bs), Type: v.Type()})
+				return
 			}
 			v.SetFloat(n)
 		}
@@ -1111,10 +1700,12 @@ func (d *Decoder) literal(bs []byte, ttype tokenType, v reflect.Value) {
 		}
 		switch v.Kind() {
 		default:
-			d.error(&UnmarshalTypeError{"rune", v.Type()})
+			d.typeError(&UnmarshalTypeError{Value: "rune", Type: v.Type()})
+			return
 		case reflect.Interface:
 			if v.NumMethod() != 0 {
-				d.error(&UnmarshalTypeError{"rune", v.Type()})
+				d.typeError(&UnmarshalTypeError{Value: "rune", Type: v.Type()})
+				return
 			}
 			v.Set(reflect.ValueOf(r))
 		case reflect.Int32: // rune is an alias for int32
@@ -1127,14 +1718,20 @@ func (d *Decoder) literal(bs []byte, ttype tokenType, v reflect.Value) {
 		}
 		switch v.Kind() {
 		default:
-			d.error(&UnmarshalTypeError{"string", v.Type()})
+			d.typeError(&UnmarshalTypeError{Value: "string", Type: v.Type()})
+			return
 		case reflect.String:
+			if v.Type() == numberType {
+				d.typeError(&UnmarshalTypeError{Value: "string", Type: v.Type()})
+				return
+			}
 			v.SetString(string(s))
 		case reflect.Interface:
 			if v.NumMethod() == 0 {
 				v.Set(reflect.ValueOf(string(s)))
 			} else {
-				d.error(&UnmarshalTypeError{"string", v.Type()})
+				d.typeError(&UnmarshalTypeError{Value: "string", Type: v.Type()})
+				return
 			}
 		}
 	default:
@@ -1142,7 +1739,40 @@ func (d *Decoder) literal(bs []byte, ttype tokenType, v reflect.Value) {
 	}
 }
 
+// floatString returns the integer or float token bs in a format accepted by
+// strconv.ParseFloat and big.Float.Parse.
+func floatString(bs []byte) string {
+	switch {
+	case bs[0] == '#': // ##Inf, ##-Inf or ##NaN
+		return string(bs[2:])
+	case bs[len(bs)-1] == 'M' || bs[len(bs)-1] == 'N': // can end with M or N, which we promptly ignore
+		return string(bs[:len(bs)-1])
+	}
+	return string(bs)
+}
+
+// decimal parses the float token bs as a Decimal.
+func (d *Decoder) decimal(bs []byte) *Decimal {
+	z, ok := new(Decimal).SetString(string(bs))
+	if !ok {
+		d.error(errInternal)
+	}
+	return z
+}
+
+// ratio parses the ratio token bs.
+func (d *Decoder) ratio(bs []byte) *big.Rat {
+	r, ok := new(big.Rat).SetString(string(bs))
+	if !ok { // the lexer only accepts valid ratios, but they may divide by zero
+		d.error(&SyntaxError{msg: "ratio " + string(bs) + " has a zero denominator"})
+	}
+	return r
+}
+
 func (d *Decoder) literalInterface(bs []byte, ttype tokenType) interface{} {
+	if d.useNumber && isNumberToken(ttype) {
+		return Number(bs)
+	}
 	switch ttype {
 	case tokenSymbol:
 		if bytes.Equal(nilByte, bs) {
@@ -1170,22 +1800,23 @@ func (d *Decoder) literalInterface(bs []byte, ttype tokenType) interface{} {
 			s := string(bs)
 			n, err := strconv.ParseInt(s, 10, 64)
 			if err != nil {
-				d.error(err)
+				d.typeError(&UnmarshalTypeError{Value: "int " + s, Type: reflect.TypeOf(n)})
+				return nil
 			}
 			return n
 		}
 	case tokenFloat:
-		var s string
-		if bs[len(bs)-1] == 'M' { // can end with M, which we promptly ignore
-			s = string(bs[:len(bs)-1])
-		} else {
-			s = string(bs)
+		if d.decimals && bs[len(bs)-1] == 'M' {
+			return d.decimal(bs)
 		}
-		n, err := strconv.ParseFloat(s, 64)
+		n, err := strconv.ParseFloat(floatString(bs), 64)
 		if err != nil {
-			d.error(err)
+			d.typeError(&UnmarshalTypeError{Value: "float " + string(bs), Type: reflect.TypeOf(n)})
+			return nil
 		}
 		return n
+	case tokenRatio:
+		return d.ratio(bs)
 	case tokenChar:
 		r, err := toRune(bs)
 		if err != nil {
@@ -1236,7 +1867,7 @@ func toRune(bs []byte) (rune, error) {
 	}
 }
 
-// nextToken handles #_
+// nextToken handles #_ and metadata
 func (d *Decoder) nextToken() ([]byte, tokenType, error) {
 	bs, tt, err := d.rawToken()
 	if err != nil {
@@ -1249,8 +1880,13 @@ func (d *Decoder) nextToken() ([]byte, tokenType, error) {
 			return nil, tokenError, err
 		}
 		return d.nextToken() // again for discards
+	case tokenMeta:
+		if d.metadata == passMetadata {
+			return bs, tt, nil
+		}
+		return d.metaToken()
 	default:
-		return bs, tt, err
+		return bs, tt, d.checkToken(bs, tt, d.tokenPos)
 	}
 }
 
@@ -1261,19 +1897,23 @@ func (d *Decoder) rawToken() ([]byte, tokenType, error) {
 		tt := d.prevTtype
 		d.prevSlice = nil
 		d.prevTtype = tokenError
+		d.tokenPos = d.prevPos
 		return b, tt, nil
 	}
+	d.meta = nil
 	var val bytes.Buffer
 	d.lex.reset()
 	doIgnore := true
 	if d.hasLeftover {
+		// the leftover rune is always the last rune read
 		d.hasLeftover = false
-		d.lex.position++
 		switch d.lex.state(d.leftover) {
 		case lexCont:
+			d.tokenPos = d.lastPos
 			val.WriteRune(d.leftover)
 			doIgnore = false
 		case lexEnd:
+			d.tokenPos = d.lastPos
 			val.WriteRune(d.leftover)
 			return val.Bytes(), d.lex.token, nil
 		case lexEndPrev:
@@ -1287,22 +1927,23 @@ func (d *Decoder) rawToken() ([]byte, tokenType, error) {
 	if doIgnore { // ignore whitespace
 	readWhitespace:
 		for {
-			r, _, err := d.rd.ReadRune()
+			r, _, err := d.readRune()
 			if err == io.EOF {
 				return nil, tokenError, errNoneLeft
 			}
 			if err != nil {
 				return nil, tokenError, err
 			}
-			d.lex.position++
 			switch d.lex.state(r) {
 			case lexCont: // got a value, so continue on past doIgnoring
 				// TODO: This returns an error. Will it happen in practice? Probably?
+				d.tokenPos = d.lastPos
 				val.WriteRune(r)
 				break readWhitespace
 			case lexError:
 				return nil, tokenError, d.lex.err
 			case lexEnd:
+				d.tokenPos = d.lastPos
 				val.WriteRune(r)
 				return val.Bytes(), d.lex.token, nil
 			case lexEndPrev:
@@ -1313,7 +1954,7 @@ func (d *Decoder) rawToken() ([]byte, tokenType, error) {
 		}
 	}
 	for {
-		r, _, err := d.rd.ReadRune()
+		r, _, err := d.readRune()
 		var ls lexState
 		// this is not exactly perfect.
 		switch {
@@ -1322,12 +1963,14 @@ func (d *Decoder) rawToken() ([]byte, tokenType, error) {
 		case err != nil:
 			return nil, tokenError, err
 		default:
-			d.lex.position++
 			ls = d.lex.state(r)
 		}
 		switch ls {
 		case lexCont:
 			val.WriteRune(r)
+			if err := d.checkTokenPrefix(val.Bytes(), d.tokenPos); err != nil {
+				return nil, tokenError, err
+			}
 		case lexIgnore:
 			if err != io.EOF {
 				return nil, tokenError, errInternal
@@ -1404,7 +2047,7 @@ func (t *tokenStack) push(tt tokenType) error {
 		t.toplevel = tt
 	}
 	switch tt {
-	case tokenMapStart, tokenVectorStart, tokenListStart, tokenSetStart, tokenDiscard, tokenTag:
+	case tokenMapStart, tokenVectorStart, tokenListStart, tokenSetStart, tokenDiscard, tokenTag, tokenMeta:
 		// append to toks, regardless
 		t.toks = append(t.toks, tokenStackElem{tt, 0})
 		return nil
@@ -1428,8 +2071,9 @@ func (t *tokenStack) push(tt tokenType) error {
 	if len(t.toks) > 0 {
 		t.toks[len(t.toks)-1].count++
 	}
-	// popping of discards and tags
-	for len(t.toks) > 0 && t.peek() == tokenTag {
+	// popping of discards, tags and metadata. Metadata is followed by two
+	// values: the metadata itself and the value it is attached to.
+	for len(t.toks) > 0 && (t.peek() == tokenTag || t.peek() == tokenMeta && t.peekCount() == 2) {
 		t.pop()
 		if len(t.toks) > 0 {
 			t.toks[len(t.toks)-1].count++
@@ -1450,21 +2094,14 @@ func (d *Decoder) more() error {
 	}
 	if d.hasLeftover && d.leftover == '#' {
 		// check if next rune is '_'
-		r, _, err := d.rd.ReadRune()
-		if err == io.EOF {
-			return errNoneLeft
-		}
-		if err != nil {
+		isDiscard, err := d.peekDiscard()
+		if err != nil || !isDiscard {
 			return err
 		}
-		if r != '_' {
-			// it's not discard, so let's just unread the rune
-			return d.rd.UnreadRune()
-		}
 		// need to consume a value
 		d.hasLeftover = false
 		d.leftover = '\uFFFD'
-		d.lex.position += 2
+		d.readRune()
 		err = d.traverseValue()
 		if err != nil {
 			return err
@@ -1484,13 +2121,12 @@ func (d *Decoder) more() error {
 		var err error
 	readWhitespace:
 		for {
-			r, _, err = d.rd.ReadRune()
+			r, _, err = d.readRune()
 			if err != nil {
 				return err
 				// if we hit the end of the line, then we don't have more and we return
 				// io.EOF
 			}
-			d.lex.position++
 			switch d.lex.state(r) {
 			case lexCont: // found something that looks like a value, so break out of whitespace loop
 				break readWhitespace
@@ -1499,7 +2135,6 @@ func (d *Decoder) more() error {
 			case lexEnd: // found a delimiter of some sort, so store it as leftover and return nil
 				d.hasLeftover = true
 				d.leftover = r
-				d.lex.position--
 				return nil
 			case lexEndPrev:
 				return errInternal
@@ -1510,24 +2145,20 @@ func (d *Decoder) more() error {
 
 		if r == '#' { // the edge case again, so let's gobble
 			// check if next rune is '_'
-			r, _, err := d.rd.ReadRune()
-			if err == io.EOF {
-				return errNoneLeft
-			}
+			isDiscard, err := d.peekDiscard()
 			if err != nil {
 				return err
 			}
-			if r != '_' {
-				// it's not discard, so we unread the rune and put # as leftover
+			if !isDiscard {
+				// it's not discard, so we put # as leftover
 				d.leftover = '#'
 				d.hasLeftover = true
-				d.lex.position--
-				return d.rd.UnreadRune()
+				return nil
 			}
 			// need to consume a value
 			d.hasLeftover = false
 			d.leftover = '\uFFFD'
-			d.lex.position += 2
+			d.readRune()
 			err = d.traverseValue()
 			if err != nil {
 				return err
@@ -1536,18 +2167,32 @@ func (d *Decoder) more() error {
 		} else { // we could do unreadrune here too, would've been just as fine
 			d.hasLeftover = true
 			d.leftover = r
-			d.lex.position--
 			return nil
 		}
 	}
 }
 
+// peekDiscard reports whether the next rune in the input is '_', without
+// consuming it.
+func (d *Decoder) peekDiscard() (bool, error) {
+	bs, err := d.rd.Peek(1)
+	if err == io.EOF {
+		return false, errNoneLeft
+	}
+	if err != nil {
+		return false, err
+	}
+	return bs[0] == '_', nil
+}
+
 // Oh, asking about why this is so similar to the part above, eh? Yes, I would
 // also consider this a crime. At least I use the same lexer. This is probably
 // next on the list when I have people complaining about perf issues.
 func (d *Decoder) nextValueBytes() ([]byte, error) {
 	// TODO: Ensure values inside maps come in pairs.
 	tstack := newTokenStack()
+	// depth of the value we're in, for the MaxDepth limit
+	depth := d.depth
 	var val bytes.Buffer
 	if d.undo {
 		d.undo = false
@@ -1558,7 +2203,13 @@ func (d *Decoder) nextValueBytes() ([]byte, error) {
 		if tt == tokenDiscard { // should be impossible to get a tokenDiscard here?
 			return nil, errInternal
 		}
+		if tt != tokenSymbol { // the collection we undid was already entered
+			depth--
+		}
 		err := tstack.push(tt)
+		if err == nil {
+			err = d.checkStackDepth(depth, tstack, d.lastPos)
+		}
 		if err != nil || tstack.done() {
 			return val.Bytes(), err
 		}
@@ -1569,17 +2220,23 @@ readElems:
 		d.lex.reset()
 		// Can't ignore whitespace in general. So I guess we just add it onto the buffer
 		readWs := true
+		// start and startPos locate the current token, for the limits
+		var start int
+		var startPos Position
 		if d.hasLeftover {
 			// we can have leftover from previous iteration. e.g. "foo[bar]" will have
 			// leftover "[" and "]"
 			d.hasLeftover = false
-			d.lex.position++
+			start, startPos = val.Len(), d.lastPos
 			val.WriteRune(d.leftover)
 			switch d.lex.state(d.leftover) {
 			case lexCont:
 				readWs = false
 			case lexEnd:
 				err := tstack.push(d.lex.token)
+				if err == nil {
+					err = d.checkStackDepth(depth, tstack, d.lastPos)
+				}
 				if err != nil || tstack.done() {
 					return val.Bytes(), err
 				}
@@ -1596,14 +2253,14 @@ readElems:
 		readWhitespace:
 			// If we end up here, it means we expect at least one more token
 			for {
-				r, _, err := d.rd.ReadRune()
+				r, _, err := d.readRune()
 				if err == io.EOF {
 					return nil, errNoneLeft
 				}
 				if err != nil {
 					return nil, err
 				}
-				d.lex.position++
+				start, startPos = val.Len(), d.lastPos
 				val.WriteRune(r)
 				switch d.lex.state(r) {
 				case lexCont: // found something that looks like a value, so break out of whitespace loop
@@ -1612,6 +2269,9 @@ readElems:
 					return nil, d.lex.err
 				case lexEnd:
 					err := tstack.push(d.lex.token)
+					if err == nil {
+						err = d.checkStackDepth(depth, tstack, d.lastPos)
+					}
 					if err != nil || tstack.done() {
 						return val.Bytes(), err
 					}
@@ -1628,7 +2288,7 @@ readElems:
 		}
 		// read element
 		for {
-			r, rlength, err := d.rd.ReadRune()
+			r, rlength, err := d.readRune()
 			var ls lexState
 			// ugh, this is not exactly perfect.
 			switch {
@@ -1637,13 +2297,14 @@ readElems:
 			case err != nil:
 				return nil, err
 			default:
-				d.lex.position++
 				val.WriteRune(r)
 				ls = d.lex.state(r)
 			}
 			switch ls {
 			case lexCont:
-				// keep going
+				if err := d.checkTokenPrefix(val.Bytes()[start:], startPos); err != nil {
+					return nil, err
+				}
 			case lexIgnore:
 				if err != io.EOF {
 					return nil, errInternal
@@ -1652,7 +2313,13 @@ readElems:
 				}
 			case lexEnd:
 				ioErr := err
-				err := tstack.push(d.lex.token)
+				err := d.checkToken(val.Bytes()[start:], d.lex.token, startPos)
+				if err == nil {
+					err = tstack.push(d.lex.token)
+				}
+				if err == nil {
+					err = d.checkStackDepth(depth, tstack, d.lastPos)
+				}
 				if err != nil || tstack.done() {
 					return val.Bytes(), err
 				}
@@ -1665,7 +2332,13 @@ readElems:
 				d.hasLeftover = true
 				d.leftover = r
 
-				err := tstack.push(d.lex.token)
+				err := d.checkToken(val.Bytes()[start:], d.lex.token, startPos)
+				if err == nil {
+					err = tstack.push(d.lex.token)
+				}
+				if err == nil {
+					err = d.checkStackDepth(depth, tstack, d.lastPos)
+				}
 				if err != nil || tstack.done() {
 					return val.Bytes(), err
 				}
@@ -6,10 +6,12 @@ package edn
 
 import (
 	"fmt"
+	"math"
 	"math/big"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 // your basic unit tests.. unfinished, probably.
@@ -469,12 +471,11 @@ func TestUnhashableBigInt(t *testing.T) {
 	input := "#{0N}"
 	var val interface{}
 	if err := UnmarshalString(input, &val); err != nil {
-		_, unhashable := err.(*UnhashableError)
-		if !unhashable {
-			t.Errorf("unexpected parsing error: %q: %s", input, err)
-		}
-	} else {
-		t.Errorf("expected '%s' to be unparseable", input)
+		t.Fatalf("unexpected parsing error: %q: %s", input, err)
+	}
+	key, _ := NewValue(big.NewInt(0))
+	if !val.(map[interface{}]bool)[key] {
+		t.Errorf("expected %q to contain %v, got %#v", input, key, val)
 	}
 }
 
@@ -482,12 +483,11 @@ func TestUnhashableTaggedList(t *testing.T) {
 	input := "{#g()0}"
 	var val interface{}
 	if err := UnmarshalString(input, &val); err != nil {
-		_, unhashable := err.(*UnhashableError)
-		if !unhashable {
-			t.Errorf("unexpected parsing error: %q: %s", input, err)
-		}
-	} else {
-		t.Errorf("expected '%s' to be unparseable", input)
+		t.Fatalf("unexpected parsing error: %q: %s", input, err)
+	}
+	key, _ := NewValue(Tag{"g", []interface{}{}})
+	if val.(map[interface{}]interface{})[key] != int64(0) {
+		t.Errorf("expected %q to contain %v, got %#v", input, key, val)
 	}
 }
 
@@ -573,3 +573,397 @@ func TestJSONDecoding(t *testing.T) {
 	testHi(&jsonAndEdn, &jsonAndEdn.Data, inputEDN)
 	testEmpty(&jsonAndEdn, &jsonAndEdn.Data, inputJSON)
 }
+
+func TestErrorPositions(t *testing.T) {
+	type Config struct {
+		Port int
+	}
+	tests := []struct {
+		input  string
+		line   int
+		column int
+		offset int64
+	}{
+		{"{:port 1}\n{:port\n  \"x\"}", 3, 3, 19},
+		{"[1 2\n 3)", 2, 3, 7},
+		{"{:port 1}\n{:port 2\n", 3, 1, 19},
+		{"[1 2 3]\n\n  {:a #_ :b ::c 1}", 3, 14, 22},
+		{"[\"æøå\" \\newlinx]", 1, 15, 17},
+		{"[1\n 99999999999999999999]", 2, 2, 4},
+		{"[1\n #inst \"xx\"]", 2, 2, 4},
+	}
+	for _, test := range tests {
+		d := NewDecoder(strings.NewReader(test.input))
+		var err error
+		for err == nil {
+			if strings.HasPrefix(test.input, "{") {
+				err = d.Decode(&Config{})
+			} else {
+				var v interface{}
+				err = d.Decode(&v)
+			}
+		}
+		var pos Position
+		switch err := err.(type) {
+		case *SyntaxError:
+			pos = err.Position
+		case *UnmarshalTypeError:
+			pos = err.Position
+		case *TagError:
+			pos = err.Position
+		default:
+			t.Errorf("%q: unexpected error type %T: %v", test.input, err, err)
+			continue
+		}
+		if pos.Line != test.line || pos.Column != test.column || pos.Offset != test.offset {
+			t.Errorf("%q: expected line %d, column %d, offset %d, got %d, %d, %d (%v)",
+				test.input, test.line, test.column, test.offset,
+				pos.Line, pos.Column, pos.Offset, err)
+		}
+	}
+}
+
+func TestErrorExcerpt(t *testing.T) {
+	var v struct{ Name string }
+	err := UnmarshalString("{:name 12}", &v)
+	ute, ok := err.(*UnmarshalTypeError)
+	if !ok {
+		t.Fatalf("expected an UnmarshalTypeError, got %v", err)
+	}
+	expected := `edn: cannot unmarshal int into Go struct field Name of type string at path [:name], line 1, column 8, near "{:name 12}"`
+	if ute.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, ute.Error())
+	}
+}
+
+func TestErrorPaths(t *testing.T) {
+	type Pool struct {
+		Timeout int
+	}
+	type DB struct {
+		Pool []Pool
+	}
+	type Config struct {
+		DB      DB `edn:"db"`
+		Servers map[string]interface{}
+	}
+	var cfg Config
+	err := UnmarshalString(`{:db {:pool [{} {} {:timeout :never}]}}`, &cfg)
+	ute, ok := err.(*UnmarshalTypeError)
+	if !ok {
+		t.Fatalf("expected an UnmarshalTypeError, got %v", err)
+	}
+	if ute.Path != "[:db :pool 2 :timeout]" {
+		t.Errorf("expected path [:db :pool 2 :timeout], got %q", ute.Path)
+	}
+	if ute.Struct != "Config" || ute.Field != "DB.Pool.Timeout" {
+		t.Errorf("expected field Config.DB.Pool.Timeout, got %s.%s", ute.Struct, ute.Field)
+	}
+	if !strings.Contains(ute.Error(), "Go struct field Config.DB.Pool.Timeout of type int") {
+		t.Errorf("expected the field in the error message, got %v", ute)
+	}
+
+	d := NewDecoder(strings.NewReader(`{:servers {"main" [1 #{:a}]} :db {:pool [{:timeout 1 :timout 2}]}}`))
+	d.DisallowUnknownFields()
+	err = d.Decode(&cfg)
+	ufe, ok := err.(*UnknownFieldError)
+	if !ok {
+		t.Fatalf("expected an UnknownFieldError, got %v", err)
+	}
+	if ufe.Path != "[:db :pool 0 :timout]" {
+		t.Errorf("expected path [:db :pool 0 :timout], got %q", ufe.Path)
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	type Config struct {
+		Name    string
+		Port    int
+		Hosts   []string
+		Debug   bool
+		Timeout time.Duration
+	}
+	input := `{:name 1 :port 8080 :hosts ["a" :b "c"] :debug "yes" :timeout #dur "10q" :extra 1}`
+	tm := new(TagMap)
+	tm.MustAddTagFn("dur", time.ParseDuration)
+
+	var cfg Config
+	d := NewDecoder(strings.NewReader(input))
+	d.UseTagMap(tm)
+	d.DisallowUnknownFields()
+	d.CollectErrors()
+	err := d.Decode(&cfg)
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected an ErrorList, got %T: %v", err, err)
+	}
+	paths := []string{"[:name]", "[:hosts 1]", "[:debug]", "[:timeout]", "[:extra]"}
+	if len(errs) != len(paths) {
+		t.Fatalf("expected %d errors, got %d: %v", len(paths), len(errs), err)
+	}
+	for i, err := range errs {
+		var path string
+		switch err := err.(type) {
+		case *UnmarshalTypeError:
+			path = err.Path
+		case *UnknownFieldError:
+			path = err.Path
+		case *TagError:
+			path = err.Path
+			if err.Tag != "dur" || err.Err == nil {
+				t.Errorf("unexpected tag error %v", err)
+			}
+		default:
+			t.Errorf("unexpected error type %T: %v", err, err)
+		}
+		if path != paths[i] {
+			t.Errorf("expected error %d to have path %s, got %s", i, paths[i], path)
+		}
+	}
+	if cfg.Port != 8080 || !reflect.DeepEqual(cfg.Hosts, []string{"a", "", "c"}) {
+		t.Errorf("expected the remaining fields to be decoded, got %#v", cfg)
+	}
+
+	// without CollectErrors, the first error is returned after decoding the
+	// rest of the value
+	cfg = Config{}
+	d = NewDecoder(strings.NewReader(input))
+	d.UseTagMap(tm)
+	err = d.Decode(&cfg)
+	if ute, ok := err.(*UnmarshalTypeError); !ok || ute.Path != "[:name]" {
+		t.Errorf("expected an UnmarshalTypeError for :name, got %v", err)
+	}
+	if cfg.Port != 8080 {
+		t.Errorf("expected the remaining fields to be decoded, got %#v", cfg)
+	}
+
+	// tag errors inside collections decoded into interfaces
+	var vals []interface{}
+	err = UnmarshalString(`[1 #inst "bad" 2]`, &vals)
+	if err == nil || !strings.Contains(err.Error(), `"bad"`) {
+		t.Errorf("expected the tag error for #inst, got %v", err)
+	}
+	d = NewDecoder(strings.NewReader(`[1 #inst "bad" 2]`))
+	d.CollectErrors()
+	err = d.Decode(&vals)
+	if errs, ok := err.(ErrorList); !ok || len(errs) != 1 {
+		t.Errorf("expected one error, got %v", err)
+	} else if _, ok := errs[0].(*TagError); !ok {
+		t.Errorf("expected a TagError, got %T: %v", errs[0], errs[0])
+	}
+	if !reflect.DeepEqual(vals, []interface{}{int64(1), nil, int64(2)}) {
+		t.Errorf("expected [1 nil 2], got %v", vals)
+	}
+
+	// syntax errors still stop decoding
+	d = NewDecoder(strings.NewReader(`{:name 1 :port 80 :hosts [}`))
+	d.CollectErrors()
+	err = d.Decode(&cfg)
+	errs, ok = err.(ErrorList)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected two errors, got %v", err)
+	}
+	if _, ok := errs[1].(*SyntaxError); !ok {
+		t.Errorf("expected the last error to be a syntax error, got %v", errs[1])
+	}
+}
+
+func TestRequiredAndDefault(t *testing.T) {
+	type DB struct {
+		Host    string        `edn:"host,required"`
+		Port    int           `edn:"port,default=5432"`
+		Hosts   []string      `edn:"hosts,default=[\"a\", \"b\"]"`
+		Timeout time.Duration `edn:"timeout,default=#duration \"5s\""`
+	}
+	type Config struct {
+		DB  DB      `edn:"db,required"`
+		Env Keyword `edn:"env,omitempty,default=:dev"`
+	}
+	d := NewDecoder(strings.NewReader(`{:db {:host "h" :port 1}} {:db {:hosts []}}`))
+	d.AddTagFn("duration", time.ParseDuration)
+	var cfg Config
+	if err := d.Decode(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	expected := Config{DB{"h", 1, []string{"a", "b"}, 5 * time.Second}, "dev"}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, cfg)
+	}
+
+	cfg = Config{}
+	err := d.Decode(&cfg)
+	mfe, ok := err.(*MissingFieldError)
+	if !ok {
+		t.Fatalf("Expected MissingFieldError, got %v", err)
+	}
+	if mfe.Field != "host" || mfe.Path != "[:db]" || mfe.Column != 32 {
+		t.Errorf("Expected :host to be missing at path [:db], column 32, got %v", err)
+	}
+	if cfg.DB.Port != 5432 || len(cfg.DB.Hosts) != 0 {
+		t.Errorf("Expected defaults to be set for absent keys only, got %+v", cfg)
+	}
+
+	// fields without options are left alone when absent
+	type Mixed struct {
+		A    int                         `edn:"a,default=1"`
+		B    int                         `edn:"b"`
+		Rest map[interface{}]interface{} `edn:",rest"`
+	}
+	for input, expected := range map[string]Mixed{
+		`{}`:          {A: 1},
+		`{:b 2 :c 3}`: {A: 1, B: 2, Rest: map[interface{}]interface{}{Keyword("c"): int64(3)}},
+	} {
+		var mixed Mixed
+		if err := UnmarshalString(input, &mixed); err != nil {
+			t.Errorf("Unexpected error for %s: %v", input, err)
+		} else if !reflect.DeepEqual(mixed, expected) {
+			t.Errorf("Expected %+v for %s, got %+v", expected, input, mixed)
+		}
+	}
+
+	var invalid struct {
+		N int `edn:"n,default=\"x\""`
+	}
+	if err := UnmarshalString(`{}`, &invalid); err == nil {
+		t.Error("Expected invalid default value to be an error")
+	}
+}
+
+func TestRestField(t *testing.T) {
+	type Doc struct {
+		Name  string
+		Count int                         `edn:"count,omitempty"`
+		Rest  map[interface{}]interface{} `edn:",rest"`
+	}
+	input := `{:name "a" :tags #{:x} "str" 1 [1 2] {:nested true} :count 3}`
+	var doc Doc
+	d := NewDecoder(strings.NewReader(input))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Name != "a" || doc.Count != 3 || len(doc.Rest) != 3 {
+		t.Fatalf("Expected fields and 3 rest entries, got %+v", doc)
+	}
+	key, _ := NewValue([]int{1, 2})
+	if !Equal(doc.Rest[key], map[interface{}]interface{}{Keyword("nested"): true}) {
+		t.Errorf("Expected unhashable rest keys to be stored as Values, got %+v", doc.Rest)
+	}
+	doc.Rest[Keyword("count")] = 4 // shadowed by the field
+	bs, err := MarshalSorted(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{:name"a":count 3"str"1 :tags #{:x}[1 2]{:nested true}}`
+	if string(bs) != expected {
+		t.Errorf("Expected %s, got %s", expected, bs)
+	}
+
+	var raw struct {
+		ID   int
+		Rest map[Keyword]RawMessage `edn:",rest"`
+	}
+	if err := UnmarshalString(`{:id 1 :when #inst "2020-01-01T00:00:00Z" :opts {:a [1 2]}}`, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(raw.Rest) != 2 || string(raw.Rest["opts"]) != "{:a [1 2]}" {
+		t.Errorf("Expected rest entries to be kept verbatim, got %q", raw.Rest)
+	}
+
+	var bad struct {
+		ID   int
+		Rest []interface{} `edn:",rest"`
+	}
+	if err := UnmarshalString(`{:id 1 :x 2}`, &bad); err == nil || !strings.Contains(err.Error(), "rest field Rest") {
+		t.Errorf("Expected error for non-map rest field, got %v", err)
+	}
+	if _, err := Marshal(bad); err == nil || !strings.Contains(err.Error(), "must be a map") {
+		t.Errorf("Expected error for non-map rest field, got %v", err)
+	}
+}
+
+func TestSymbolicValues(t *testing.T) {
+	var fs []float64
+	if err := UnmarshalString(`[##Inf ##-Inf ##NaN]`, &fs); err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 3 || !math.IsInf(fs[0], 1) || !math.IsInf(fs[1], -1) || !math.IsNaN(fs[2]) {
+		t.Errorf("Expected [+Inf -Inf NaN], got %v", fs)
+	}
+	var f32 float32
+	if err := UnmarshalString(`##-Inf`, &f32); err != nil || !math.IsInf(float64(f32), -1) {
+		t.Errorf("Expected -Inf, got %v (err: %v)", f32, err)
+	}
+	var val interface{}
+	if err := UnmarshalString(`{:max ##Inf}`, &val); err != nil {
+		t.Fatal(err)
+	}
+	if max := val.(map[interface{}]interface{})[Keyword("max")]; max != math.Inf(1) {
+		t.Errorf("Expected +Inf, got %#v", max)
+	}
+	var bf big.Float
+	if err := UnmarshalString(`##Inf`, &bf); err != nil || !bf.IsInf() || bf.Sign() != 1 {
+		t.Errorf("Expected +Inf, got %v (err: %v)", &bf, err)
+	}
+	if _, ok := UnmarshalString(`##NaN`, &bf).(*UnmarshalTypeError); !ok {
+		t.Errorf("Expected NaN to be a type error for big.Float")
+	}
+
+	for _, invalid := range []string{`##`, `##inf`, `##Infinity`, `##-NaN`, `## Inf`, `##Inf2`} {
+		if _, ok := UnmarshalString(invalid, &val).(*SyntaxError); !ok {
+			t.Errorf("Expected %s to be a syntax error", invalid)
+		}
+	}
+}
+
+func TestRatios(t *testing.T) {
+	var r big.Rat
+	if err := UnmarshalString(`-22/7`, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Cmp(big.NewRat(-22, 7)) != 0 {
+		t.Errorf("Expected -22/7, got %v", &r)
+	}
+	var val interface{}
+	if err := UnmarshalString(`[1/3 +4/2]`, &val); err != nil {
+		t.Fatal(err)
+	}
+	vec := val.([]interface{})
+	if r, ok := vec[0].(*big.Rat); !ok || r.Cmp(big.NewRat(1, 3)) != 0 {
+		t.Errorf("Expected *big.Rat 1/3, got %#v", vec[0])
+	}
+	if r, ok := vec[1].(*big.Rat); !ok || r.Cmp(big.NewRat(2, 1)) != 0 {
+		t.Errorf("Expected *big.Rat 2/1, got %#v", vec[1])
+	}
+
+	var f float64
+	if err := UnmarshalString(`1/3`, &f); err != nil || f != 1.0/3 {
+		t.Errorf("Expected 1/3 to decode into %v, got %v (err: %v)", 1.0/3, f, err)
+	}
+	dec := NewDecoder(strings.NewReader(`1/3`))
+	dec.UseMathContext(MathContext{Precision: 64, Mode: big.AwayFromZero})
+	if err := dec.Decode(&f); err != nil || f != math.Nextafter(1.0/3, 1) {
+		t.Errorf("Expected 1/3 to be rounded away from zero, got %v (err: %v)", f, err)
+	}
+	var f32 float32
+	if err := UnmarshalString(`1/1000000000000000000000000000000000000000000000`, &f32); err != nil || f32 != 1e-45 {
+		t.Errorf("Expected 1e-45, got %v (err: %v)", f32, err)
+	}
+	if _, ok := UnmarshalString(`10000000000000000000000000000000000000000/1`, &f32).(*UnmarshalTypeError); !ok {
+		t.Errorf("Expected overflowing ratio to be a type error")
+	}
+	var bf big.Float
+	if err := UnmarshalString(`1/4`, &bf); err != nil || bf.Cmp(big.NewFloat(0.25)) != 0 {
+		t.Errorf("Expected 0.25, got %v (err: %v)", &bf, err)
+	}
+	var n int
+	if _, ok := UnmarshalString(`1/4`, &n).(*UnmarshalTypeError); !ok {
+		t.Errorf("Expected ratio to be a type error for int")
+	}
+
+	for _, invalid := range []string{`1/`, `1/x`, `1/2/3`, `1/2N`, `1.5/2`, `1/0`} {
+		if _, ok := UnmarshalString(invalid, &val).(*SyntaxError); !ok {
+			t.Errorf("Expected %s to be a syntax error", invalid)
+		}
+	}
+}
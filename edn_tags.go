@@ -20,7 +20,6 @@ import (
 	"math/big"
 	"reflect"
 	"sync"
-	"time"
 )
 
 var (
@@ -28,15 +27,31 @@ var (
 	ErrMismatchArities = errors.New("Function does not have single argument in, two argument out")
 	ErrNotConcrete     = errors.New("Value is not a concrete non-function type")
 	ErrTagOverwritten  = errors.New("Previous tag implementation was overwritten")
+	ErrNotBuiltinTag   = errors.New("Tag is not a built-in tag")
+	ErrMismatchTypes   = errors.New("Function types do not match the registered type")
 )
 
 var globalTags TagMap
 
+// builtinTags contains the built-in tags. They are used when neither the
+// decoder's TagMap nor globalTags has a tag with the same name, so that users
+// can replace them without an ErrTagOverwritten.
+var builtinTags TagMap
+
 // A TagMap contains mappings from tag literals to functions and structs that is
-// used when decoding.
+// used when decoding, and mappings from types to tag literals that is used when
+// encoding.
 type TagMap struct {
 	sync.RWMutex
-	m map[string]reflect.Value
+	m     map[string]reflect.Value
+	types map[reflect.Type]typeTag
+}
+
+// A typeTag is the tag and conversion function a registered type is encoded
+// with.
+type typeTag struct {
+	name string
+	toFn reflect.Value // invalid if the value is encoded as is
 }
 
 var errorType = reflect.TypeOf((*error)(nil)).Elem()
@@ -47,13 +62,9 @@ var errorType = reflect.TypeOf((*error)(nil)).Elem()
 func (tm *TagMap) AddTagFn(tagname string, fn interface{}) error {
 	// TODO: check name
 	rfn := reflect.ValueOf(fn)
-	rtyp := rfn.Type()
-	if rtyp.Kind() != reflect.Func {
-		return ErrNotFunc
-	}
-	if rtyp.NumIn() != 1 || rtyp.NumOut() != 2 || !rtyp.Out(1).Implements(errorType) {
-		// ok to have variadic arity?
-		return ErrMismatchArities
+	// ok to have variadic arity?
+	if err := checkTagFn(rfn.Type()); err != nil {
+		return err
 	}
 	return tm.addVal(tagname, rfn)
 }
@@ -107,6 +118,92 @@ func (tm *TagMap) AddTagStruct(tagname string, val interface{}) error {
 	return tm.addVal(tagname, rstruct)
 }
 
+// RegisterType registers the type of example with the tag tagname, so that
+// values of that type are encoded and decoded as tagged values.
+//
+// When encoding, a value of the registered type is converted with toFn, which
+// must have the signature func(T) (U, error), and the result is encoded after
+// the tag. If toFn is nil, the value itself is encoded after the tag. The
+// registration applies wherever the value is found, including behind
+// interfaces and pointers, and takes precedence over the Marshaler interface.
+//
+// When decoding, fromFn is used as a tag function for tagname, see AddTagFn.
+// It must return a value assignable to the registered type, or a pointer to
+// such a value. If fromFn is nil, the tagged value is decoded into a value of
+// the registered type, as with AddTagStruct.
+//
+// Encoders use the global TagMap and the TagMap set with Encoder.UseTagMap.
+func (tm *TagMap) RegisterType(tagname string, example, toFn, fromFn interface{}) error {
+	if !isValidTagName(tagname) {
+		return ErrInvalidTag
+	}
+	typ := reflect.TypeOf(example)
+	if typ == nil {
+		return ErrNotConcrete
+	}
+	tt := typeTag{name: tagname}
+	if toFn != nil {
+		rfn := reflect.ValueOf(toFn)
+		if err := checkTagFn(rfn.Type()); err != nil {
+			return err
+		}
+		if !typ.AssignableTo(rfn.Type().In(0)) {
+			return ErrMismatchTypes
+		}
+		tt.toFn = rfn
+	}
+	var err error
+	if fromFn != nil {
+		rfn := reflect.ValueOf(fromFn)
+		if err := checkTagFn(rfn.Type()); err != nil {
+			return err
+		}
+		out := rfn.Type().Out(0)
+		if !out.AssignableTo(typ) && !(out.Kind() == reflect.Ptr && out.Elem().AssignableTo(typ)) {
+			return ErrMismatchTypes
+		}
+		err = tm.addVal(tagname, rfn)
+	} else {
+		err = tm.AddTagStruct(tagname, example)
+	}
+	if err != nil && err != ErrTagOverwritten {
+		return err
+	}
+	tm.Lock()
+	if tm.types == nil {
+		tm.types = map[reflect.Type]typeTag{}
+	}
+	tm.types[typ] = tt
+	tm.Unlock()
+	registerEncodedType(typ)
+	return err
+}
+
+// RegisterType registers the type of example with the tag tagname in the
+// global TagMap. See TagMap.RegisterType for details.
+func RegisterType(tagname string, example, toFn, fromFn interface{}) error {
+	return globalTags.RegisterType(tagname, example, toFn, fromFn)
+}
+
+// typeTag returns the tag t is registered with in this TagMap.
+func (tm *TagMap) typeTag(t reflect.Type) (typeTag, bool) {
+	tm.RLock()
+	tt, ok := tm.types[t]
+	tm.RUnlock()
+	return tt, ok
+}
+
+// checkTagFn checks that t is the type of a tag function.
+func checkTagFn(t reflect.Type) error {
+	if t.Kind() != reflect.Func {
+		return ErrNotFunc
+	}
+	if t.NumIn() != 1 || t.NumOut() != 2 || !t.Out(1).Implements(errorType) {
+		return ErrMismatchArities
+	}
+	return nil
+}
+
 // AddTagStructs adds the struct as a matching struct for tagname tags to the
 // global TagMap. val can not be a channel, function, interface or an unsafe
 // pointer. See Decoder.AddTagStruct for examples.
@@ -115,20 +212,14 @@ func AddTagStruct(tagname string, val interface{}) error {
 }
 
 func init() {
-	err := AddTagFn("inst", func(s string) (time.Time, error) {
-		return time.Parse(time.RFC3339Nano, s)
-	})
-	if err != nil {
-		panic(err)
-	}
-	err = AddTagFn("base64", base64.StdEncoding.DecodeString)
-	if err != nil {
-		panic(err)
-	}
+	builtinTags.MustAddTagFn("inst", parseInst)
+	builtinTags.MustAddTagFn("base64", base64.StdEncoding.DecodeString)
+	builtinTags.MustAddTagFn("uuid", ParseUUID)
 }
 
 // A MathContext specifies the precision and rounding mode for
-// `math/big.Float`s when decoding.
+// `math/big.Float`s when decoding. The rounding mode is also used when decoding
+// ratios into float32 and float64 values.
 type MathContext struct {
 	Precision uint
 	Mode      big.RoundingMode
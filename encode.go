@@ -39,9 +39,13 @@ import (
 //
 // Boolean values encode as EDN booleans.
 //
-// Integers encode as EDN integers.
+// Integers encode as EDN integers. big.Int values encode as EDN integers with
+// the N suffix, big.Float values as EDN floats with the M suffix, and big.Rat
+// values as EDN ratios.
 //
-// Floating point values encode as EDN floats.
+// Floating point values encode as EDN floats. NaN and infinities cannot be
+// encoded by Marshal, but an Encoder can encode them as the symbolic values
+// ##NaN, ##Inf and ##-Inf, see Encoder.UseSymbolicValues.
 //
 // String values encode as EDN strings coerced to valid UTF-8,
 // replacing invalid bytes with the Unicode replacement rune.
@@ -93,6 +97,54 @@ import (
 //    // Encode Foo as symbol with name sym-foo
 //    Foo int `edn:"sym-foo,sym"`
 //
+// The "ns=" option gives the field name a namespace. The field is decoded
+// from the namespaced key only. Example:
+//
+//    // Encode Name as :user/name
+//    Name string `edn:"name,ns=user"`
+//
+// The "required" and "default=" options are used when decoding only. Decoding
+// a map without the key of a required field results in a MissingFieldError.
+// If the key of a field with a default value is absent, the default value is
+// decoded into the field instead. The default value is EDN, and extends to
+// the end of the tag, so it must be the last option. Examples:
+//
+//    // Decoding fails unless the map contains :host
+//    Host string `edn:"host,required"`
+//
+//    // Port is 8080 unless the map contains :port
+//    Port int `edn:"port,default=8080"`
+//
+//    // Any EDN value can be used as a default value
+//    Hosts []string `edn:"hosts,omitempty,default=[\"localhost\"]"`
+//
+// The "rest" option on a map field collects the entries of the map which do
+// not match any other field when decoding. When encoding, the entries of the
+// field are written as entries of the struct, except those with the same key
+// as another field. The key and value types of the map decide how the entries
+// are decoded, so use map[interface{}]interface{} to keep arbitrary keys and
+// values, or map[Keyword]RawMessage to keep them exactly as they were. Encoding
+// or decoding a struct with a "rest" field that is not a map returns an error.
+// Example:
+//
+//    // Keys other than :name are kept in Other
+//    Name  string
+//    Other map[interface{}]interface{} `edn:",rest"`
+//
+// The "meta" option makes a field hold the metadata of the struct instead of
+// a map entry, see MetadataPolicy. If the field is not empty, the struct is
+// encoded with the field as its metadata, so the field must encode as an EDN
+// map. When decoding with KeepMetadata, the metadata of the map is stored in
+// the field. Example:
+//
+//    // ^{:doc "..."} {:name "..."} decodes into Meta and Name
+//    Name string
+//    Meta map[interface{}]interface{} `edn:",meta"`
+//
+// Fields without a name in their tag are named by the naming strategy of the
+// Encoder, which by default lower-cases the first letter of the field name.
+// See NamingStrategy and FieldNamer.
+//
 // Anonymous struct fields are usually marshaled as if their inner exported fields
 // were fields in the outer struct, subject to the usual Go visibility rules amended
 // as described in the next paragraph.
@@ -143,9 +195,9 @@ import (
 // Attempting to encode such a value causes Marshal to return
 // an UnsupportedTypeError.
 //
-// EDN cannot represent cyclic data structures and Marshal does not
-// handle them. Passing cyclic structures to Marshal will result in
-// an infinite recursion.
+// EDN cannot represent cyclic data structures. Marshal returns an
+// UnsupportedValueError if it encounters a cycle. An Encoder can encode
+// cyclic and shared pointers as references, see Encoder.UseReferences.
 //
 func Marshal(v interface{}) ([]byte, error) {
 	e := &encodeState{}
@@ -156,6 +208,27 @@ func Marshal(v interface{}) ([]byte, error) {
 	return e.Bytes(), nil
 }
 
+// MarshalSorted is like Marshal, but writes the entries of maps and the
+// elements of sets in a deterministic order. This is useful when the output is
+// compared, diffed or stored in version control.
+//
+// Entries and elements are ordered by the EDN encoding of their keys: First by
+// type, in the order nil, booleans, numbers, characters, strings, symbols,
+// keywords, tagged values, lists, vectors, maps and sets. Values of the same
+// type are then ordered by numeric value for numbers, by code point for
+// characters and lexicographically for strings, symbols, keywords and tag names.
+// Collections are compared element by element, where a shorter collection sorts
+// before a longer one with the same prefix. Integers sort before floats of the
+// same numeric value.
+func MarshalSorted(v interface{}) ([]byte, error) {
+	e := &encodeState{sortKeys: true}
+	err := e.marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
 // MarshalIndent is like Marshal but applies Indent to format the output.
 func MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
 	b, err := Marshal(v)
@@ -198,6 +271,28 @@ func NewEncoder(w io.Writer) *Encoder {
 	}
 }
 
+// SortKeys makes the encoder write map entries and set elements in a
+// deterministic order. See MarshalSorted for the ordering used.
+func (e *Encoder) SortKeys() {
+	e.ec.sortKeys = true
+}
+
+// UseSymbolicValues makes the encoder encode NaN, positive infinity and
+// negative infinity as the symbolic values ##NaN, ##Inf and ##-Inf. These are
+// read by Clojure 1.9 and later, but not by all EDN readers. Without symbolic
+// values, encoding them returns an UnsupportedValueError.
+func (e *Encoder) UseSymbolicValues() {
+	e.ec.symbolic = true
+}
+
+// UseTagMap makes the encoder encode types registered in tm with
+// TagMap.RegisterType as tagged values. Types registered in the global TagMap
+// are encoded as tagged values regardless, but registrations in tm take
+// precedence.
+func (e *Encoder) UseTagMap(tm *TagMap) {
+	e.ec.tagmap = tm
+}
+
 // Encode writes the EDN encoding of v to the stream, followed by a newline
 // character.
 //
@@ -291,7 +386,8 @@ func (e *UnsupportedTypeError) Error() string {
 }
 
 // An UnsupportedValueError is returned by Marshal when attempting to encode an
-// unsupported value. Examples include the float values NaN and Infinity.
+// unsupported value. Examples include the float values NaN and Infinity, unless
+// the Encoder uses symbolic values, and cyclic data structures.
 type UnsupportedValueError struct {
 	Value reflect.Value
 	Str   string
@@ -320,6 +416,37 @@ type encodeState struct {
 	scratch      [64]byte
 	needsDelim   bool
 	mc           *MathContext
+	sortKeys     bool
+	tagmap       *TagMap
+	naming       *NamingStrategy
+	references   bool
+	symbolic     bool
+	instUTC      bool
+	instMillis   bool
+	noMeta       bool // ignore metadata
+	smallBigInts bool // write big integers that fit in an int64 without N
+	// pointers, maps and slices being encoded, to detect cycles
+	visiting map[ptrKey]struct{}
+	// path to the value being encoded, for error messages
+	path []encPathElem
+	// shared pointers, if encoding with references
+	refs *refState
+}
+
+// sub returns a new, empty encodeState with the same options as e.
+func (e *encodeState) sub() *encodeState {
+	return &encodeState{
+		mc:           e.mc,
+		sortKeys:     e.sortKeys,
+		tagmap:       e.tagmap,
+		naming:       e.naming,
+		symbolic:     e.symbolic,
+		instUTC:      e.instUTC,
+		instMillis:   e.instMillis,
+		noMeta:       e.noMeta,
+		smallBigInts: e.smallBigInts,
+		visiting:     e.visiting,
+	}
 }
 
 // mathContext returns the math context to use. If not set in the encodeState,
@@ -354,7 +481,18 @@ func (e *encodeState) marshal(v interface{}) (err error) {
 			err = r.(error)
 		}
 	}()
-	e.reflectValue(reflect.ValueOf(v))
+	// a previous call may have failed halfway through
+	e.visiting = nil
+	e.path = e.path[:0]
+	rv := reflect.ValueOf(v)
+	if e.references {
+		counter := e.sub()
+		counter.refs = &refState{counting: true, count: make(map[ptrKey]int)}
+		counter.reflectValue(rv)
+		e.refs = &refState{count: counter.refs.count, ids: make(map[ptrKey]int)}
+		defer func() { e.refs = nil }()
+	}
+	e.reflectValue(rv)
 	return nil
 }
 
@@ -412,6 +550,7 @@ func typeEncoder(t reflect.Type, tagType tagType) encoderFunc {
 		return f
 	}
 	couldUseJSON := readCanUseJSONTag()
+	registrations := atomic.LoadInt32(&registeredTypes.count)
 
 	// To deal with recursive types, populate the map with an
 	// indirect func before we build it. This type waits on the
@@ -434,7 +573,7 @@ func typeEncoder(t reflect.Type, tagType tagType) encoderFunc {
 	f = newTypeEncoder(t, tagType, true)
 	wg.Done()
 	encoderCache.Lock()
-	if couldUseJSON != readCanUseJSONTag() {
+	if couldUseJSON != readCanUseJSONTag() || registrations != atomic.LoadInt32(&registeredTypes.count) {
 		// cache has been invalidated, unlock and retry recursively.
 		encoderCache.Unlock()
 		return typeEncoder(t, tagType)
@@ -447,26 +586,53 @@ func typeEncoder(t reflect.Type, tagType tagType) encoderFunc {
 var (
 	marshalerType = reflect.TypeOf(new(Marshaler)).Elem()
 	instType      = reflect.TypeOf((*time.Time)(nil)).Elem()
+	tagStructType = reflect.TypeOf(Tag{})
 )
 
 // newTypeEncoder constructs an encoderFunc for a type.
 // The returned encoder only checks CanAddr when allowAddr is true.
 func newTypeEncoder(t reflect.Type, tagType tagType, allowAddr bool) encoderFunc {
+	// Registered types are looked up when encoding, as they depend on the
+	// TagMap of the encodeState. Pointers to registered types must be handled
+	// here as well, as they may implement Marshaler.
+	if isRegisteredType(t) || (t.Kind() == reflect.Ptr && isRegisteredType(t.Elem())) {
+		re := &registeredEncoder{t: t, elseEnc: newUnregisteredTypeEncoder(t, tagType, allowAddr)}
+		return re.encode
+	}
+	return newUnregisteredTypeEncoder(t, tagType, allowAddr)
+}
+
+// newUnregisteredTypeEncoder is like newTypeEncoder, but ignores types
+// registered with RegisterType.
+func newUnregisteredTypeEncoder(t reflect.Type, tagType tagType, allowAddr bool) encoderFunc {
+	// Tags are encoded in place to retain the options of the encodeState
+	if t == tagStructType {
+		return tagEncoder
+	}
+	if t == withMetaType {
+		return withMetaEncoder
+	}
 	if t.Implements(marshalerType) {
 		return marshalerEncoder
 	}
 	if t.Kind() != reflect.Ptr && allowAddr {
 		if reflect.PtrTo(t).Implements(marshalerType) {
-			return newCondAddrEncoder(addrMarshalerEncoder, newTypeEncoder(t, tagType, false))
+			return newCondAddrEncoder(addrMarshalerEncoder, newUnregisteredTypeEncoder(t, tagType, false))
 		}
 	}
 
 	// Handle specific types first
 	switch t {
+	case listType:
+		return newSliceEncoder(t, tagList)
+	case setType:
+		return newMapEncoder(t, tagSet)
 	case bigIntType:
 		return bigIntEncoder
 	case bigFloatType:
 		return bigFloatEncoder
+	case bigRatType:
+		return bigRatEncoder
 	case instType:
 		return instEncoder
 	}
@@ -547,6 +713,100 @@ func addrMarshalerEncoder(e *encodeState, v reflect.Value) {
 	}
 }
 
+// registeredTypes contains all types registered with RegisterType in any
+// TagMap. Only these types have to be looked up in TagMaps when encoding.
+var registeredTypes struct {
+	sync.RWMutex
+	m     map[reflect.Type]bool
+	count int32 // number of types in m, read and written atomically
+}
+
+func isRegisteredType(t reflect.Type) bool {
+	registeredTypes.RLock()
+	ok := registeredTypes.m[t]
+	registeredTypes.RUnlock()
+	return ok
+}
+
+// registerEncodedType marks t as registered, invalidating the encoder cache if
+// t was not registered before.
+func registerEncodedType(t reflect.Type) {
+	if isRegisteredType(t) {
+		return
+	}
+	encoderCache.Lock()
+	registeredTypes.Lock()
+	if registeredTypes.m == nil {
+		registeredTypes.m = make(map[reflect.Type]bool)
+	}
+	registeredTypes.m[t] = true
+	atomic.AddInt32(&registeredTypes.count, 1)
+	encoderCache.m = nil
+	registeredTypes.Unlock()
+	encoderCache.Unlock()
+}
+
+// registeredEncoder encodes a type which is registered in some TagMap. If the
+// type is not registered in a TagMap the encodeState uses, elseEnc is used.
+type registeredEncoder struct {
+	t       reflect.Type
+	elseEnc encoderFunc
+}
+
+func (re *registeredEncoder) encode(e *encodeState, v reflect.Value) {
+	t := re.t
+	isPtr := !isRegisteredType(t) // pointer to registered type
+	if isPtr {
+		if v.IsNil() {
+			re.elseEnc(e, v)
+			return
+		}
+		t = t.Elem()
+	}
+	tt, ok := typeTag{}, false
+	if e.tagmap != nil {
+		tt, ok = e.tagmap.typeTag(t)
+	}
+	if !ok {
+		tt, ok = globalTags.typeTag(t)
+	}
+	if !ok {
+		re.elseEnc(e, v)
+		return
+	}
+	if isPtr { // encode the pointer as the value it points to
+		e.reflectValue(v.Elem())
+		return
+	}
+	e.ensureDelim()
+	e.WriteByte('#')
+	e.WriteString(tt.name)
+	e.needsDelim = true
+	if !tt.toFn.IsValid() {
+		re.elseEnc(e, v)
+		return
+	}
+	res := tt.toFn.Call([]reflect.Value{v})
+	if err, ok := res[1].Interface().(error); ok && err != nil {
+		e.error(&MarshalerError{re.t, err})
+	}
+	if res[0].IsValid() && res[0].Type() == re.t {
+		// don't tag the converted value again
+		re.elseEnc(e, res[0])
+		return
+	}
+	e.reflectValue(res[0])
+}
+
+func tagEncoder(e *encodeState, v reflect.Value) {
+	t := v.Interface().(Tag)
+	e.ensureDelim()
+	e.WriteByte('#')
+	e.WriteString(t.Tagname)
+	e.needsDelim = true
+	e.reflectValue(reflect.ValueOf(t.Value))
+}
+
 func boolEncoder(e *encodeState, v reflect.Value) {
 	e.ensureDelim()
 	if v.Bool() {
@@ -581,15 +841,24 @@ func bigIntEncoder(e *encodeState, v reflect.Value) {
 	bi := v.Interface().(big.Int)
 	b := []byte(bi.String())
 	e.Write(b)
-	e.WriteByte('N')
+	if !e.smallBigInts || !bi.IsInt64() {
+		e.WriteByte('N')
+	}
 	e.needsDelim = true
 }
 
 func bigFloatEncoder(e *encodeState, v reflect.Value) {
+	val := v.Interface().(big.Float)
+	if val.IsInf() {
+		if !e.symbolic {
+			e.error(&UnsupportedValueError{v, val.String()})
+		}
+		e.symbolicFloat(math.Inf(val.Sign()))
+		return
+	}
 	e.ensureDelim()
 	bf := new(big.Float)
 	mc := e.mathContext()
-	val := v.Interface().(big.Float)
 	bf.Set(&val).SetMode(mc.Mode)
 	b := []byte(bf.Text('g', int(mc.Precision)))
 	e.Write(b)
@@ -597,10 +866,11 @@ func bigFloatEncoder(e *encodeState, v reflect.Value) {
 	e.needsDelim = true
 }
 
-func instEncoder(e *encodeState, v reflect.Value) {
+func bigRatEncoder(e *encodeState, v reflect.Value) {
 	e.ensureDelim()
-	t := v.Interface().(time.Time)
-	e.Write([]byte(t.Format(`#inst"` + time.RFC3339Nano + `"`)))
+	r := v.Interface().(big.Rat)
+	e.WriteString(r.String())
+	e.needsDelim = true
 }
 
 type floatEncoder int // number of bits
@@ -608,10 +878,33 @@ type floatEncoder int // number of bits
 func (bits floatEncoder) encode(e *encodeState, v reflect.Value) {
 	f := v.Float()
 	if math.IsInf(f, 0) || math.IsNaN(f) {
-		e.error(&UnsupportedValueError{v, strconv.FormatFloat(f, 'g', -1, int(bits))})
+		if !e.symbolic {
+			e.error(&UnsupportedValueError{v, strconv.FormatFloat(f, 'g', -1, int(bits))})
+		}
+		e.symbolicFloat(f)
+		return
+	}
+	e.float(f, int(bits))
+}
+
+// symbolicFloat writes the NaN or infinity f as a symbolic value.
+func (e *encodeState) symbolicFloat(f float64) {
+	e.ensureDelim()
+	switch {
+	case math.IsNaN(f):
+		e.WriteString("##NaN")
+	case f > 0:
+		e.WriteString("##Inf")
+	default:
+		e.WriteString("##-Inf")
 	}
+	e.needsDelim = true
+}
+
+// float writes f as an EDN float. f must be a finite number.
+func (e *encodeState) float(f float64, bits int) {
 	e.ensureDelim()
-	b := strconv.AppendFloat(e.scratch[:0], f, 'g', -1, int(bits))
+	b := strconv.AppendFloat(e.scratch[:0], f, 'g', -1, bits)
 	if ix := bytes.IndexAny(b, ".eE"); ix < 0 {
 		b = append(b, '.', '0')
 	}
@@ -641,48 +934,113 @@ func unsupportedTypeEncoder(e *encodeState, v reflect.Value) {
 }
 
 type structEncoder struct {
+	t         reflect.Type
+	naming    *NamingStrategy // strategy naming the fields, nil for the default
 	fields    []field
 	fieldEncs []encoderFunc
 }
 
 func (se *structEncoder) encode(e *encodeState, v reflect.Value) {
+	if e.naming != se.naming {
+		se = e.naming.structEncoder(se.t)
+	}
+	fields := se.fields
+	if f := metaField(fields); f != nil {
+		e.writeMeta(fieldByIndex(v, f.index))
+	}
 	e.WriteByte('{')
 	e.needsDelim = false
-	for i, f := range se.fields {
+	for i, f := range fields {
 		fv := fieldByIndex(v, f.index)
-		if !fv.IsValid() || f.omitEmpty && isEmptyValue(fv) {
+		if !fv.IsValid() || f.omitEmpty && isEmptyValue(fv) || f.meta {
 			continue
 		}
-		switch f.fnameType {
-		case emitKey:
-			e.ensureDelim()
-			e.WriteByte(':')
-			e.WriteString(f.name)
-			e.needsDelim = true
-		case emitString:
-			e.string(f.name)
-			e.needsDelim = false
-		case emitSym:
-			e.ensureDelim()
-			e.WriteString(f.name)
-			e.needsDelim = true
+		if f.rest {
+			if err := checkRestField(se.t, &fields[i]); err != nil {
+				e.error(err)
+			}
+			e.restEntries(fields, fv)
+			continue
 		}
+		e.fieldKey(&fields[i])
+		e.pushField(&fields[i])
 		se.fieldEncs[i](e, fv)
+		e.popPath()
 	}
 	e.WriteByte('}')
 	e.needsDelim = false
 }
 
+// fieldKey writes the key of the struct field f.
+func (e *encodeState) fieldKey(f *field) {
+	switch f.fnameType {
+	case emitKey:
+		e.ensureDelim()
+		e.WriteByte(':')
+		e.WriteString(f.name)
+		e.needsDelim = true
+	case emitString:
+		e.string(f.name)
+		e.needsDelim = false
+	case emitSym:
+		e.ensureDelim()
+		e.WriteString(f.name)
+		e.needsDelim = true
+	}
+}
+
+// restEntries writes the entries of the map m, the rest field of a struct with
+// the given fields. Entries with the same key as one of the fields are skipped.
+func (e *encodeState) restEntries(fields []field, m reflect.Value) {
+	if m.IsNil() || m.Len() == 0 {
+		return
+	}
+	fieldKeys := make(map[string]bool, len(fields))
+	for i := range fields {
+		if fields[i].rest || fields[i].meta {
+			continue
+		}
+		sub := e.sub()
+		sub.fieldKey(&fields[i])
+		fieldKeys[sub.String()] = true
+	}
+	keyEnc := typeEncoder(m.Type().Key(), tagUndefined)
+	elemEnc := typeEncoder(m.Type().Elem(), tagUndefined)
+	mk := m.MapKeys()
+	if e.sortKeys {
+		e.sortValues(mk, keyEnc)
+	}
+	for _, k := range mk {
+		sub := e.sub()
+		keyEnc(sub, k)
+		if fieldKeys[sub.String()] {
+			continue
+		}
+		keyEnc(e, k)
+		e.pushKey(k)
+		elemEnc(e, m.MapIndex(k))
+		e.popPath()
+	}
+}
+
 func newStructEncoder(t reflect.Type, tagType tagType) encoderFunc {
-	fields := cachedTypeFields(t)
+	return makeStructEncoder(t, nil).encode
+}
+
+// makeStructEncoder returns the structEncoder for the struct type t, with its
+// fields named by ns.
+func makeStructEncoder(t reflect.Type, ns *NamingStrategy) *structEncoder {
+	fields := cachedTypeFields(t, ns)
 	se := &structEncoder{
+		t:         t,
+		naming:    ns,
 		fields:    fields,
 		fieldEncs: make([]encoderFunc, len(fields)),
 	}
 	for i, f := range fields {
 		se.fieldEncs[i] = typeEncoder(typeByIndex(t, f.index), f.tagType)
 	}
-	return se.encode
+	return se
 }
 
 type mapEncoder struct {
@@ -695,21 +1053,28 @@ func (me *mapEncoder) encode(e *encodeState, v reflect.Value) {
 		e.writeNil()
 		return
 	}
+	e.enter(v)
 	e.WriteByte('{')
 	e.needsDelim = false
 	mk := v.MapKeys()
-	// NB: We don't get deterministic results here, because we don't iterate in a
-	// determinstic way.
+	// NB: We don't get deterministic results here unless we sort the keys,
+	// because we don't iterate in a determinstic way.
+	if e.sortKeys {
+		e.sortValues(mk, me.keyEnc)
+	}
 	for _, k := range mk {
 		if e.needsDelim { // bypass conventional whitespace to use commas instead
 			e.WriteByte(',')
 			e.needsDelim = false
 		}
+		e.pushKey(k)
 		me.keyEnc(e, k)
 		me.elemEnc(e, v.MapIndex(k))
+		e.popPath()
 	}
 	e.WriteByte('}')
 	e.needsDelim = false
+	e.leave(v)
 }
 
 type mapSetEncoder struct {
@@ -721,20 +1086,40 @@ func (me *mapSetEncoder) encode(e *encodeState, v reflect.Value) {
 		e.writeNil()
 		return
 	}
+	e.enter(v)
 	e.ensureDelim()
 	e.WriteByte('#')
 	e.WriteByte('{')
 	e.needsDelim = false
 	mk := v.MapKeys()
-	// not deterministic this one either.
-	for _, k := range mk {
-		mval := v.MapIndex(k)
-		if mval.Kind() != reflect.Bool || mval.Bool() {
+	// not deterministic this one either, unless sorted.
+	if e.sortKeys {
+		elems := mk[:0]
+		for _, k := range mk {
+			mval := v.MapIndex(k)
+			if mval.Kind() != reflect.Bool || mval.Bool() {
+				elems = append(elems, k)
+			}
+		}
+		e.sortValues(elems, me.keyEnc)
+		for _, k := range elems {
+			e.pushKey(k)
 			me.keyEnc(e, k)
+			e.popPath()
+		}
+	} else {
+		for _, k := range mk {
+			mval := v.MapIndex(k)
+			if mval.Kind() != reflect.Bool || mval.Bool() {
+				e.pushKey(k)
+				me.keyEnc(e, k)
+				e.popPath()
+			}
 		}
 	}
 	e.WriteByte('}')
 	e.needsDelim = false
+	e.leave(v)
 }
 
 func newMapEncoder(t reflect.Type, tagType tagType) encoderFunc {
@@ -806,7 +1191,9 @@ func (se *sliceEncoder) encode(e *encodeState, v reflect.Value) {
 		e.writeNil()
 		return
 	}
+	e.enter(v)
 	se.arrayEnc(e, v)
+	e.leave(v)
 }
 
 func newSliceEncoder(t reflect.Type, tagType tagType) encoderFunc {
@@ -827,7 +1214,9 @@ func (ae *arrayEncoder) encode(e *encodeState, v reflect.Value) {
 	e.needsDelim = false
 	n := v.Len()
 	for i := 0; i < n; i++ {
+		e.pushIndex(i)
 		ae.elemEnc(e, v.Index(i))
+		e.popPath()
 	}
 	e.WriteByte(']')
 	e.needsDelim = false
@@ -842,7 +1231,9 @@ func (ae *listArrayEncoder) encode(e *encodeState, v reflect.Value) {
 	e.needsDelim = false
 	n := v.Len()
 	for i := 0; i < n; i++ {
+		e.pushIndex(i)
 		ae.elemEnc(e, v.Index(i))
+		e.popPath()
 	}
 	e.WriteByte(')')
 	e.needsDelim = false
@@ -859,7 +1250,9 @@ func (ae *setArrayEncoder) encode(e *encodeState, v reflect.Value) {
 	e.needsDelim = false
 	n := v.Len()
 	for i := 0; i < n; i++ {
+		e.pushIndex(i)
 		ae.elemEnc(e, v.Index(i))
+		e.popPath()
 	}
 	e.WriteByte('}')
 	e.needsDelim = false
@@ -888,7 +1281,12 @@ func (pe *ptrEncoder) encode(e *encodeState, v reflect.Value) {
 		e.writeNil()
 		return
 	}
+	if e.refs != nil && e.reference(v) {
+		return
+	}
+	e.enter(v)
 	pe.elemEnc(e, v.Elem())
+	e.leave(v)
 }
 
 func newPtrEncoder(t reflect.Type, tagType tagType) encoderFunc {
@@ -1089,6 +1487,11 @@ type field struct {
 	omitEmpty bool
 	fnameType emitType
 	tagType   tagType
+
+	required     bool
+	defaultValue []byte // EDN value to decode if the key is absent, or nil
+	rest         bool   // field holds the entries of unknown keys
+	meta         bool   // field holds the metadata of the struct
 }
 
 type emitType int
@@ -1159,8 +1562,9 @@ func (x byIndex) Less(i, j int) bool {
 
 // typeFields returns a list of fields that edn should recognize for the given type.
 // The algorithm is breadth-first search over the set of structs to include - the top struct
-// and then any reachable anonymous structs.
-func typeFields(t reflect.Type) []field {
+// and then any reachable anonymous structs. Fields without a name in their tag
+// are named by ns, unless the struct containing them is a FieldNamer.
+func typeFields(t reflect.Type, ns *NamingStrategy) []field {
 	// Anonymous fields to explore at the current level and the next.
 	current := []field{}
 	next := []field{{typ: t}}
@@ -1184,6 +1588,7 @@ func typeFields(t reflect.Type) []field {
 				continue
 			}
 			visited[f.typ] = true
+			naming := typeNaming(f.typ, ns)
 
 			// Scan f.typ for fields to include.
 			for i := 0; i < f.typ.NumField(); i++ {
@@ -1199,6 +1604,7 @@ func typeFields(t reflect.Type) []field {
 					continue
 				}
 				name, opts := parseTag(tag)
+				opts, def, hasDefault := opts.cut("default")
 				if !isValidTag(name) {
 					name = ""
 				}
@@ -1246,18 +1652,27 @@ func typeFields(t reflect.Type) []field {
 				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
 					tagged := name != ""
 					if name == "" {
-						r := []rune(sf.Name)
-						r[0] = unicode.ToLower(r[0])
-						name = string(r)
+						name = naming.fn(sf.Name)
+					}
+					if ns, ok := opts.Get("ns"); ok && ns != "" {
+						name = ns + "/" + name
+					}
+					var defaultValue []byte
+					if hasDefault {
+						defaultValue = []byte(def)
 					}
 					fields = append(fields, fillField(field{
-						name:      name,
-						tag:       tagged,
-						index:     index,
-						typ:       ft,
-						omitEmpty: opts.Contains("omitempty"),
-						fnameType: emit,
-						tagType:   tagType,
+						name:         name,
+						tag:          tagged,
+						index:        index,
+						typ:          ft,
+						omitEmpty:    opts.Contains("omitempty"),
+						fnameType:    emit,
+						tagType:      tagType,
+						required:     opts.Contains("required"),
+						defaultValue: defaultValue,
+						rest:         opts.Contains("rest"),
+						meta:         opts.Contains("meta"),
 					}))
 					if count[f.typ] > 1 {
 						// If there were multiple instances, add a second,
@@ -1391,7 +1806,11 @@ var fieldCache struct {
 }
 
 // cachedTypeFields is like typeFields but uses a cache to avoid repeated work.
-func cachedTypeFields(t reflect.Type) []field {
+// Fields named by a NamingStrategy are cached by the strategy itself.
+func cachedTypeFields(t reflect.Type, ns *NamingStrategy) []field {
+	if ns != nil {
+		return ns.typeFields(t)
+	}
 	fieldCache.RLock()
 	f := fieldCache.m[t]
 	fieldCache.RUnlock()
@@ -1402,7 +1821,7 @@ func cachedTypeFields(t reflect.Type) []field {
 
 	// Compute fields without lock.
 	// Might duplicate effort but won't hold other computations back.
-	f = typeFields(t)
+	f = typeFields(t, nil)
 	if f == nil {
 		f = []field{}
 	}
@@ -1411,7 +1830,7 @@ func cachedTypeFields(t reflect.Type) []field {
 	if couldUseJSON != readCanUseJSONTag() {
 		// cache has been invalidated, unlock and retry recursively.
 		fieldCache.Unlock()
-		return cachedTypeFields(t)
+		return cachedTypeFields(t, ns)
 	}
 	if fieldCache.m == nil {
 		fieldCache.m = map[reflect.Type][]field{}
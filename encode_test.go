@@ -6,6 +6,8 @@ package edn
 
 import (
 	"bytes"
+	"math"
+	"math/big"
 	"testing"
 )
 
@@ -173,3 +175,96 @@ func TestJSONEncoding(t *testing.T) {
 	testEncode(t, jsonOnly, `{:data"hi"}`)
 	testEncode(t, jsonAndEdn, `{:edn"hi"}`)
 }
+
+func TestMarshalSorted(t *testing.T) {
+	type Point struct {
+		X, Y int
+	}
+	for _, tc := range []struct {
+		val      interface{}
+		expected string
+	}{
+		{map[int]string{10: "a", -2: "b", 3: "c"}, `{-2"b"3"c"10"a"}`},
+		{map[string]bool{"b": true, "a": true, "c": false}, `#{"a""b"}`},
+		{map[Keyword]int{"b": 1, "a": 2, "aa": 3}, `{:a 2,:aa 3,:b 1}`},
+		{map[interface{}]int{
+			Keyword("k"): 1, Symbol("s"): 2, "str": 3, 2.5: 4, int64(2): 5,
+			nil: 6, true: 7, Rune('c'): 8,
+		}, `{nil 6,true 7,2 5,2.5 4,\c 8,"str"3,s 2,:k 1}`},
+		{map[[2]int]int{{1, 2}: 1, {1, 1}: 2, {0, 9}: 3}, `{[0 9]3,[1 1]2,[1 2]1}`},
+		{map[Point]bool{{2, 1}: true, {1, 2}: true, {1, 1}: true}, `#{{:x 1 :y 1}{:x 1 :y 2}{:x 2 :y 1}}`},
+		{Tag{"set", map[int]bool{3: true, 1: true, 2: true}}, `#set #{1 2 3}`},
+	} {
+		for i := 0; i < 5; i++ { // map iteration is random, so try a couple of times
+			bs, err := MarshalSorted(tc.val)
+			if err != nil {
+				t.Errorf("Unexpected error marshalling %v: %s", tc.val, err)
+			} else if string(bs) != tc.expected {
+				t.Errorf("Expected to see '%s', but got '%s' instead", tc.expected, string(bs))
+			}
+		}
+	}
+}
+
+func TestEncoderSortKeys(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SortKeys()
+	val := map[Keyword]map[string]int{
+		"z": {"b": 1, "a": 2},
+		"y": {"d": 3, "c": 4},
+	}
+	if err := enc.Encode(val); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{:y{"c"4,"d"3}:z{"a"2,"b"1}}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Expected to see %q, but got %q instead", expected, buf.String())
+	}
+}
+
+func TestNamespaceOption(t *testing.T) {
+	type User struct {
+		Name  string `edn:"name,ns=user"`
+		Email string `edn:",ns=user,omitempty"`
+		ID    int    `edn:"id,sym,ns=db"`
+	}
+	bs, err := Marshal(User{"alice", "", 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != `{:user/name"alice"db/id 1}` {
+		t.Errorf("Expected namespaced keys, got %s", bs)
+	}
+	var u User
+	if err := UnmarshalString(`{:user/name "bob" :user/email "bob@example.com" :name "eve"}`, &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "bob" || u.Email != "bob@example.com" {
+		t.Errorf("Expected namespaced keys to be decoded, got %+v", u)
+	}
+}
+
+func TestEncodeSymbolicValues(t *testing.T) {
+	vals := []interface{}{math.Inf(1), float32(math.Inf(-1)), math.NaN(), new(big.Float).SetInf(false)}
+	for _, val := range vals {
+		if _, err := Marshal(val); err == nil {
+			t.Errorf("Expected error when encoding %v without symbolic values", val)
+		}
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.UseSymbolicValues()
+	if err := enc.Encode(vals); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "[##Inf ##-Inf ##NaN ##Inf]\n" {
+		t.Errorf("Expected symbolic values, got %s", buf.String())
+	}
+}
+
+func TestEncodeRatios(t *testing.T) {
+	testEncode(t, big.NewRat(-22, 7), "-22/7")
+	testEncode(t, big.NewRat(4, 2), "2/1")
+	testEncode(t, []*big.Rat{big.NewRat(1, 3), big.NewRat(1, 2)}, "[1/3 1/2]")
+}
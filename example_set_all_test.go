@@ -98,6 +98,6 @@ func Example_enumsAndSets() {
 	// show email? true, notifications? true, daily email? false, remember me? true
 	// show email? true, notifications? true, daily email? true, remember me? true
 	// Unknown user option :doot-doot
-	// edn: cannot unmarshal keyword into Go value of type map[edn_test.UserOption]bool
+	// edn: cannot unmarshal keyword into Go value of type map[edn_test.UserOption]bool at line 1, column 1, near ":none"
 	// show email? false, notifications? false, daily email? false, remember me? false
 }
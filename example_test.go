@@ -1,6 +1,7 @@
 package edn_test
 
 import (
+	"bytes"
 	"fmt"
 	"math/big"
 	"strings"
@@ -31,7 +32,7 @@ func ExampleDecoder_AddTagFn_duration() {
 
 	// Output:
 	// 2h30m0s
-	// time: unknown unit moment in duration 1moment
+	// edn: #com.myapp/duration: time: unknown unit "moment" in duration "1moment" at line 1, column 1, near "#com.myapp/duration \"1moment\""
 }
 
 func ExampleDecoder_AddTagFn_complex() {
@@ -279,3 +280,32 @@ func ExampleMarshal_setOverride() {
 	// {:bool-map{2 false}}
 	// {:struct-map{"foo"{}}}
 }
+
+func ExampleTagMap_RegisterType() {
+	var tm edn.TagMap
+	tm.RegisterType("length/foot", Foot(0), nil, nil)
+	tm.RegisterType("length/yard", Yard(0), nil, nil)
+	// values can also be converted before they are encoded
+	tm.RegisterType("length/cm", Metre(0),
+		func(m Metre) (float64, error) { return float64(m) * 100, nil },
+		func(cm float64) (Metre, error) { return Metre(cm / 100), nil })
+
+	var buf bytes.Buffer
+	enc := edn.NewEncoder(&buf)
+	enc.UseTagMap(&tm)
+	enc.Encode([]Length{Foot(3), Yard(1), Metre(0.5)})
+	fmt.Print(buf.String())
+
+	dec := edn.NewDecoder(&buf)
+	dec.UseTagMap(&tm)
+	var lengths []Length
+	dec.Decode(&lengths)
+	for _, length := range lengths {
+		fmt.Printf("%T: %.4f metres\n", length, length.ToMetres())
+	}
+	// Output:
+	// [#length/foot 3.0 #length/yard 1.0 #length/cm 50.0]
+	// edn_test.Foot: 0.9144 metres
+	// edn_test.Yard: 0.9144 metres
+	// edn_test.Metre: 0.5000 metres
+}
@@ -57,20 +57,23 @@ func (l *Loglevel) UnmarshalEDN(bs []byte) error {
 	}
 }
 
+// Required fields must be present in the config, and fields with a default
+// value are set to it if absent. Both are handled by the decoder, so no
+// separate validation pass is necessary.
 type DbConf struct {
-	User     string
+	User     string `edn:"user,required"`
 	Password string `edn:"pwd"`
-	Host     string
-	Db       string
-	Port     int
+	Host     string `edn:"host,required"`
+	Db       string `edn:"db,required"`
+	Port     int    `edn:"port,default=5432"`
 }
 
 type MyappConf struct {
-	Port         int
+	Port         int `edn:"port,default=3000"`
 	Features     FeatureSet
 	FooSetup     FooConfig `edn:"foo"`
 	ForeverDate  time.Time `edn:"forever-date"`
-	ProcessCount int       `edn:"process-pool"`
+	ProcessCount int       `edn:"process-pool,default=4"`
 }
 
 type FeatureSet map[Feature]bool
@@ -13,6 +13,7 @@ func main() {
 	check_help()
 
 	d := edn.NewDecoder(os.Stdin)
+	d.UseCollectionTypes()
 	e := edn.NewEncoder(os.Stdout)
 
 	var err error
@@ -41,8 +42,8 @@ edn_pp reads EDN-encoded input from stdin and prints EDN-encoded output
 to stdout. For more information about EDN, see
 https://github.com/edn-format/edn
 
-edn_pp will not distinguish between lists and vectors, and will always
-print out vectors.
+Lists, vectors and sets are printed with the same brackets as in the
+input.
 
 To print this information, call edn_pp with --help, -h, --version or -v.`
 
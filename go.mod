@@ -1,3 +1,5 @@
 module olympos.io/encoding/edn
 
 go 1.13
+
+require github.com/satori/go.uuid v1.2.0
@@ -0,0 +1,155 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// UseUTCInsts makes the encoder convert times to UTC before writing them as
+// #inst, and write their offset as -00:00 instead of Z, like clojure.instant
+// does.
+func (e *Encoder) UseUTCInsts() {
+	e.ec.instUTC = true
+}
+
+// UseMillisecondInsts makes the encoder write times as #inst with exactly
+// three fractional digits, like clojure.instant does. Times are truncated to
+// millisecond precision. By default, times are written with as many
+// fractional digits as needed, and none for whole seconds.
+func (e *Encoder) UseMillisecondInsts() {
+	e.ec.instMillis = true
+}
+
+func instEncoder(e *encodeState, v reflect.Value) {
+	e.ensureDelim()
+	t := v.Interface().(time.Time)
+	layout := time.RFC3339Nano
+	if e.instMillis {
+		layout = "2006-01-02T15:04:05.000Z07:00"
+	}
+	if e.instUTC {
+		t = t.UTC()
+		layout = layout[:len(layout)-len("Z07:00")] + "-00:00"
+	}
+	e.Write([]byte(t.Format(`#inst"` + layout + `"`)))
+}
+
+// parseInst parses s as an instant in the subset of RFC 3339 described by the
+// EDN specification, where all parts after the year are optional:
+//
+//	yyyy[-MM[-dd[Thh[:mm[:ss[.fff]]]]]][Z|±hh:mm]
+//
+// The fraction may have any number of digits. As in clojure.instant, missing
+// parts default to their smallest value, and a missing offset means UTC.
+func parseInst(s string) (time.Time, error) {
+	p := instParser{s: s, ok: true}
+	year := p.digits(4)
+	month, day, hour, min, sec, nsec := 1, 1, 0, 0, 0, 0
+	parts := []struct {
+		sep byte
+		val *int
+	}{{'-', &month}, {'-', &day}, {'T', &hour}, {':', &min}, {':', &sec}}
+	n := 0
+	for _, part := range parts {
+		if p.atOffset() || !p.accept(part.sep) {
+			break
+		}
+		*part.val = p.digits(2)
+		n++
+	}
+	if n == len(parts) && p.accept('.') {
+		nsec = p.fraction()
+	}
+	loc := time.UTC
+	if !p.accept('Z') && p.atOffset() {
+		sign := 1
+		if p.s[p.i] == '-' {
+			sign = -1
+		}
+		p.i++
+		oh := p.digits(2)
+		p.accept(':')
+		om := p.digits(2)
+		if oh > 23 || om > 59 {
+			p.ok = false
+		}
+		if offset := sign * (oh*60 + om) * 60; offset != 0 {
+			loc = time.FixedZone("", offset)
+		}
+	}
+	maxSec := 59
+	if min == 59 {
+		maxSec = 60 // leap second
+	}
+	if !p.ok || p.i != len(s) || month < 1 || month > 12 || day < 1 ||
+		day > daysIn(time.Month(month), year) || hour > 23 || min > 59 || sec > maxSec {
+		return time.Time{}, fmt.Errorf("invalid instant %q", s)
+	}
+	return time.Date(year, time.Month(month), day, hour, min, sec, nsec, loc), nil
+}
+
+func daysIn(m time.Month, year int) int {
+	return time.Date(year, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// instParser reads the parts of an instant. If a part cannot be read, ok is
+// set to false.
+type instParser struct {
+	s  string
+	i  int
+	ok bool
+}
+
+// digits reads exactly n decimal digits.
+func (p *instParser) digits(n int) int {
+	if p.i+n > len(p.s) {
+		p.ok = false
+		return 0
+	}
+	v := 0
+	for _, c := range []byte(p.s[p.i : p.i+n]) {
+		if c < '0' || '9' < c {
+			p.ok = false
+			return 0
+		}
+		v = v*10 + int(c-'0')
+	}
+	p.i += n
+	return v
+}
+
+// fraction reads at least one decimal digit after the decimal point, and
+// returns the fraction in nanoseconds. Digits beyond nanoseconds are ignored.
+func (p *instParser) fraction() int {
+	start := p.i
+	nsec, scale := 0, 1000000000
+	for p.i < len(p.s) && '0' <= p.s[p.i] && p.s[p.i] <= '9' {
+		scale /= 10
+		nsec += int(p.s[p.i]-'0') * scale
+		p.i++
+	}
+	if p.i == start {
+		p.ok = false
+	}
+	return nsec
+}
+
+func (p *instParser) accept(c byte) bool {
+	if p.i < len(p.s) && p.s[p.i] == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// atOffset reports whether the rest of the input is an offset of the form
+// ±hh:mm. This distinguishes negative offsets from months and days.
+func (p *instParser) atOffset() bool {
+	rest := p.s[p.i:]
+	return len(rest) == 6 && (rest[0] == '+' || rest[0] == '-') && rest[3] == ':'
+}
@@ -27,6 +27,7 @@ const ( // value types from lexer
 	tokenString
 	tokenInt
 	tokenFloat
+	tokenRatio
 	tokenTag
 	tokenChar
 	tokenListStart
@@ -37,6 +38,7 @@ const ( // value types from lexer
 	tokenMapEnd
 	tokenSetStart
 	tokenDiscard
+	tokenMeta
 
 	tokenError
 )
@@ -53,6 +55,8 @@ func (t tokenType) String() string {
 		return "integer"
 	case tokenFloat:
 		return "float"
+	case tokenRatio:
+		return "ratio"
 	case tokenTag:
 		return "tag"
 	case tokenChar:
@@ -73,6 +77,8 @@ func (t tokenType) String() string {
 		return "set start"
 	case tokenDiscard:
 		return "discard token"
+	case tokenMeta:
+		return "metadata"
 	case tokenError:
 		return "error"
 	default:
@@ -82,14 +88,17 @@ func (t tokenType) String() string {
 
 const tokenSetEnd = tokenMapEnd // sets ends the same way as maps do
 
-// A SyntaxError is a description of an EDN syntax error.
+// A SyntaxError is a description of an EDN syntax error. The Position is the
+// position of the offending character in the input, and Excerpt contains the
+// part of the input line around it, if available.
 type SyntaxError struct {
-	msg    string // description of error
-	Offset int64  // error occurred after reading Offset bytes
+	msg string // description of error
+	Position
+	Excerpt string
 }
 
 func (e *SyntaxError) Error() string {
-	return e.msg
+	return "edn: " + e.msg + errorContext("", e.Position, e.Excerpt)
 }
 
 func okSymbolFirst(r rune) bool {
@@ -113,10 +122,9 @@ func isWhitespace(r rune) bool {
 }
 
 type lexer struct {
-	state    func(rune) lexState
-	err      error
-	position int64
-	token    tokenType
+	state func(rune) lexState
+	err   error
+	token tokenType
 
 	count     int    // counter is used in some functions within the lexer
 	expecting []rune // expecting is used to avoid duplication when we expect e.g. \newline
@@ -134,7 +142,7 @@ func (l *lexer) eof() lexState {
 	}
 	lt := l.state(' ')
 	if lt == lexCont {
-		l.err = &SyntaxError{"unexpected end of EDN input", l.position}
+		l.err = &SyntaxError{msg: "unexpected end of EDN input"}
 		lt = lexError
 	}
 	if l.err != nil {
@@ -171,6 +179,9 @@ func (l *lexer) stateBegin(r rune) lexState {
 	case r == '#':
 		l.state = l.statePound
 		return lexCont
+	case r == '^':
+		l.token = tokenMeta
+		return lexEnd
 	case r == ':':
 		l.state = l.stateKeyword
 		return lexCont
@@ -229,11 +240,11 @@ func (l *lexer) stateKeyword(r rune) lexState {
 	switch {
 	case r == ':':
 		l.state = l.stateError
-		l.err = &SyntaxError{"EDN does not support namespace-qualified keywords", l.position}
+		l.err = &SyntaxError{msg: "EDN does not support namespace-qualified keywords"}
 		return lexError
 	case r == '/':
 		l.state = l.stateError
-		l.err = &SyntaxError{"keywords cannot begin with /", l.position}
+		l.err = &SyntaxError{msg: "keywords cannot begin with /"}
 		return lexError
 	case okSymbol(r) || u.IsLetter(r) || ('0' <= r && r <= '9'):
 		l.token = tokenKeyword
@@ -339,11 +350,32 @@ func (l *lexer) state0(r rune) lexState {
 		l.token = tokenInt
 		l.state = l.stateEndLit
 		return lexCont // must be ws or delimiter afterwards
+	case r == '/': // ratio
+		l.state = l.stateRatio
+		return lexCont
 	}
 	l.token = tokenInt
 	return l.stateEndLit(r)
 }
 
+// after reading an integer plus '/', example: '22/'
+func (l *lexer) stateRatio(r rune) lexState {
+	if '0' <= r && r <= '9' {
+		l.state = l.stateRatio0
+		return lexCont
+	}
+	return l.error(r, "after '/' in ratio")
+}
+
+// after reading an integer, '/' and at least one digit, example: '22/7'
+func (l *lexer) stateRatio0(r rune) lexState {
+	if '0' <= r && r <= '9' {
+		return lexCont
+	}
+	l.token = tokenRatio
+	return l.stateEndLit(r)
+}
+
 // anything but a result starting with 0. example '10', '34'
 func (l *lexer) state1(r rune) lexState {
 	if '0' <= r && r <= '9' {
@@ -474,7 +506,7 @@ func (l *lexer) stateChar(r rune) lexState {
 		return lexCont
 	case isWhitespace(r):
 		l.state = l.stateError
-		l.err = &SyntaxError{"backslash cannot be followed by whitespace", l.position}
+		l.err = &SyntaxError{msg: "backslash cannot be followed by whitespace"}
 		return lexError
 	}
 	// default is single name character
@@ -568,6 +600,9 @@ func (l *lexer) statePound(r rune) lexState {
 	case r == '{':
 		l.token = tokenSetStart
 		return lexEnd
+	case r == '#':
+		l.state = l.stateSymbolic
+		return lexCont
 	case u.IsLetter(r):
 		l.token = tokenTag
 		l.state = l.stateSym
@@ -576,6 +611,42 @@ func (l *lexer) statePound(r rune) lexState {
 	return l.error(r, `after token starting with "#"`)
 }
 
+var (
+	infRunes    = []rune("Inf")
+	negInfRunes = []rune("-Inf")
+	nanRunes    = []rune("NaN")
+)
+
+// stateSymbolic after '##', which starts one of the symbolic values ##Inf,
+// ##-Inf and ##NaN. They are lexed as floats.
+func (l *lexer) stateSymbolic(r rune) lexState {
+	switch r {
+	case 'I':
+		l.expecting = infRunes
+	case '-':
+		l.expecting = negInfRunes
+	case 'N':
+		l.expecting = nanRunes
+	default:
+		return l.error(r, `after token starting with "##"`)
+	}
+	l.count = 1
+	l.state = l.stateInSymbolic
+	return lexCont
+}
+
+func (l *lexer) stateInSymbolic(r rune) lexState {
+	if r != l.expecting[l.count] {
+		return l.error(r, "in symbolic value")
+	}
+	l.count++
+	if l.count == len(l.expecting) {
+		l.token = tokenFloat
+		l.state = l.stateEndLit
+	}
+	return lexCont
+}
+
 func (l *lexer) stateError(r rune) lexState {
 	return lexError
 }
@@ -583,7 +654,7 @@ func (l *lexer) stateError(r rune) lexState {
 // error records an error and switches to the error state.
 func (l *lexer) error(r rune, context string) lexState {
 	l.state = l.stateError
-	l.err = &SyntaxError{"invalid character " + quoteRune(r) + " " + context, l.position}
+	l.err = &SyntaxError{msg: "invalid character " + quoteRune(r) + " " + context}
 	return lexError
 }
 
@@ -0,0 +1,144 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"strconv"
+)
+
+// DecoderLimits bounds the resources a Decoder may spend on a single value,
+// for use with untrusted input. A zero field means that the corresponding
+// limit is not enforced. When a limit is exceeded, decoding stops with a
+// LimitError.
+type DecoderLimits struct {
+	// MaxDepth is the maximal nesting depth of lists, vectors, maps, sets and
+	// tagged values.
+	MaxDepth int
+	// MaxBytes is the maximal number of bytes read while decoding a single
+	// value, including whitespace and comments in front of it. For Token,
+	// More and Skip, it applies to each call.
+	MaxBytes int64
+	// MaxStringLength is the maximal length in bytes of an encoded string,
+	// excluding the quotes.
+	MaxStringLength int
+	// MaxElements is the maximal number of elements in a single list, vector
+	// or set, and the maximal number of entries in a single map.
+	MaxElements int
+	// MaxNumberDigits is the maximal number of characters in an integer, a
+	// float or a ratio, excluding the sign and the N or M suffix. Parsing of
+	// big numbers is superlinear in the number of digits, so this should be
+	// set when decoding into *big.Int, *big.Float or *big.Rat.
+	MaxNumberDigits int
+}
+
+// A LimitError is returned when a Decoder exceeds one of its DecoderLimits.
+type LimitError struct {
+	Limit    string // name of the limit, e.g. "MaxDepth"
+	Max      int64  // the value of the limit
+	Position        // position in the input where the limit was exceeded
+	Excerpt  string // the input around Position, if available
+}
+
+func (e *LimitError) Error() string {
+	return "edn: input exceeds " + e.Limit + " (" + strconv.FormatInt(e.Max, 10) + ")" +
+		errorContext("", e.Position, e.Excerpt)
+}
+
+// UseLimits sets the limits the Decoder enforces. By default, a Decoder has
+// no limits.
+func (d *Decoder) UseLimits(limits DecoderLimits) {
+	d.limits = limits
+}
+
+func (d *Decoder) limitError(limit string, max int64, pos Position) error {
+	return &LimitError{
+		Limit:    limit,
+		Max:      max,
+		Position: pos,
+		Excerpt:  d.excerpt(pos),
+	}
+}
+
+// enter increments the nesting depth of the decoder. The caller must
+// decrement d.depth when the collection or tagged value has been read.
+func (d *Decoder) enter() {
+	d.depth++
+	if d.limits.MaxDepth > 0 && d.depth > d.limits.MaxDepth {
+		d.error(d.limitError("MaxDepth", int64(d.limits.MaxDepth), d.tokenPos))
+	}
+}
+
+// checkStackDepth checks that the collections and tags in t, nested depth
+// levels deep, are within the limits. pos is the position of the last token
+// pushed onto t.
+func (d *Decoder) checkStackDepth(depth int, t *tokenStack, pos Position) error {
+	if max := d.limits.MaxDepth; max > 0 && depth+len(t.toks) > max {
+		return d.limitError("MaxDepth", int64(max), pos)
+	}
+	return nil
+}
+
+// checkElements checks that a collection with n elements is within the limits.
+func (d *Decoder) checkElements(n int) {
+	if d.limits.MaxElements > 0 && n > d.limits.MaxElements {
+		d.error(d.limitError("MaxElements", int64(d.limits.MaxElements), d.tokenPos))
+	}
+}
+
+// checkToken checks that the token bs at pos is within the limits.
+func (d *Decoder) checkToken(bs []byte, tt tokenType, pos Position) error {
+	switch tt {
+	case tokenString:
+		if max := d.limits.MaxStringLength; max > 0 && len(bs)-2 > max {
+			return d.limitError("MaxStringLength", int64(max), pos)
+		}
+	case tokenInt, tokenFloat, tokenRatio:
+		if max := d.limits.MaxNumberDigits; max > 0 && numberDigits(bs) > max {
+			return d.limitError("MaxNumberDigits", int64(max), pos)
+		}
+	}
+	return nil
+}
+
+// checkTokenPrefix checks that bs, the start of a token at pos that is still
+// being read, is within the limits. This rejects long strings and numbers
+// before they are buffered in full. checkToken does the exact check once the
+// token is complete.
+func (d *Decoder) checkTokenPrefix(bs []byte, pos Position) error {
+	switch {
+	case bs[0] == '"':
+		// the closing quote is only read at the end of the token
+		if max := d.limits.MaxStringLength; max > 0 && len(bs)-1 > max {
+			return d.limitError("MaxStringLength", int64(max), pos)
+		}
+	case isDigit(bs[0]) || (bs[0] == '-' || bs[0] == '+') && len(bs) > 1 && isDigit(bs[1]):
+		// allow for a suffix that has not been read yet
+		n := len(bs)
+		if !isDigit(bs[0]) {
+			n--
+		}
+		if max := d.limits.MaxNumberDigits; max > 0 && n > max+1 {
+			return d.limitError("MaxNumberDigits", int64(max), pos)
+		}
+	}
+	return nil
+}
+
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
+// numberDigits returns the length of the numeric token bs, excluding the sign
+// and suffix.
+func numberDigits(bs []byte) int {
+	n := len(bs)
+	if bs[0] == '-' || bs[0] == '+' {
+		n--
+	}
+	if last := bs[len(bs)-1]; last == 'N' || last == 'M' {
+		n--
+	}
+	return n
+}
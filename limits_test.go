@@ -0,0 +1,123 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestDecoderLimits(t *testing.T) {
+	type Wrapped struct {
+		Raw RawMessage
+	}
+	tests := []struct {
+		input  string
+		limits DecoderLimits
+		into   func() interface{}
+		limit  string
+		line   int
+		column int
+	}{
+		{strings.Repeat("[", 5) + strings.Repeat("]", 5), DecoderLimits{MaxDepth: 4},
+			func() interface{} { return new(interface{}) }, "MaxDepth", 1, 5},
+		{strings.Repeat("[", 5) + strings.Repeat("]", 5), DecoderLimits{MaxDepth: 4},
+			func() interface{} { return new([][][][][]int) }, "MaxDepth", 1, 5},
+		{"#a #b #c #d #e 1", DecoderLimits{MaxDepth: 4},
+			func() interface{} { return new(interface{}) }, "MaxDepth", 1, 13},
+		{"{:raw [[[[[]]]]]}", DecoderLimits{MaxDepth: 4},
+			func() interface{} { return new(Wrapped) }, "MaxDepth", 1, 10},
+		{"  [1 2 3 4 5 6 7 8]", DecoderLimits{MaxBytes: 10},
+			func() interface{} { return new(interface{}) }, "MaxBytes", 1, 11},
+		{`["short" "a bit longer"]`, DecoderLimits{MaxStringLength: 5},
+			func() interface{} { return new([]string) }, "MaxStringLength", 1, 10},
+		{"#{1 2\n3 4}", DecoderLimits{MaxElements: 3},
+			func() interface{} { return new(map[int]bool) }, "MaxElements", 2, 3},
+		{"{:a 1 :b 2 :c 3}", DecoderLimits{MaxElements: 2},
+			func() interface{} { return new(interface{}) }, "MaxElements", 1, 12},
+		{"[1 " + strings.Repeat("9", 40) + "N]", DecoderLimits{MaxNumberDigits: 30},
+			func() interface{} { return new([]*big.Int) }, "MaxNumberDigits", 1, 4},
+		{`{:raw [1 "abcdef"]}`, DecoderLimits{MaxStringLength: 5},
+			func() interface{} { return new(Wrapped) }, "MaxStringLength", 1, 10},
+		{"{:raw [" + strings.Repeat("9", 31) + "N]}", DecoderLimits{MaxNumberDigits: 30},
+			func() interface{} { return new(Wrapped) }, "MaxNumberDigits", 1, 8},
+	}
+	for _, test := range tests {
+		d := NewDecoder(strings.NewReader(test.input))
+		d.UseLimits(test.limits)
+		err := d.Decode(test.into())
+		le, ok := err.(*LimitError)
+		if !ok {
+			t.Errorf("%q: expected a LimitError, got %v", test.input, err)
+			continue
+		}
+		if le.Limit != test.limit || le.Line != test.line || le.Column != test.column {
+			t.Errorf("%q: expected %s at line %d, column %d, got %v",
+				test.input, test.limit, test.line, test.column, le)
+		}
+	}
+}
+
+func TestDecoderLimitsPerValue(t *testing.T) {
+	d := NewDecoder(strings.NewReader("[1 2] [3 4] [5 6]"))
+	d.UseLimits(DecoderLimits{MaxBytes: 6, MaxDepth: 1, MaxElements: 2})
+	for i := 0; i < 3; i++ {
+		var v []int
+		if err := d.Decode(&v); err != nil {
+			t.Fatalf("Expected value %d to be within limits, got %v", i, err)
+		}
+	}
+}
+
+// endlessReader reads prefix followed by an endless repetition of fill.
+type endlessReader struct {
+	prefix string
+	fill   byte
+	n      int
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		if r.n < len(r.prefix) {
+			p[i] = r.prefix[r.n]
+		} else {
+			p[i] = r.fill
+		}
+		r.n++
+	}
+	return len(p), nil
+}
+
+func TestDecoderLimitsLongTokens(t *testing.T) {
+	tests := []struct {
+		r      *endlessReader
+		limits DecoderLimits
+		limit  string
+	}{
+		{&endlessReader{prefix: `"`, fill: 'a'}, DecoderLimits{MaxStringLength: 100}, "MaxStringLength"},
+		{&endlessReader{prefix: "-", fill: '9'}, DecoderLimits{MaxNumberDigits: 100}, "MaxNumberDigits"},
+	}
+	for _, test := range tests {
+		d := NewDecoder(test.r)
+		d.UseLimits(test.limits)
+		var v interface{}
+		err := d.Decode(&v)
+		if le, ok := err.(*LimitError); !ok || le.Limit != test.limit {
+			t.Errorf("%q: expected %s to be exceeded, got %v", test.r.prefix, test.limit, err)
+		}
+		if test.r.n > 1<<16 {
+			t.Errorf("%q: expected the token to be rejected early, read %d bytes", test.r.prefix, test.r.n)
+		}
+	}
+
+	// tokens right at the limits are accepted
+	d := NewDecoder(strings.NewReader(`["abcde" -` + strings.Repeat("9", 30) + "N]"))
+	d.UseLimits(DecoderLimits{MaxStringLength: 5, MaxNumberDigits: 30})
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		t.Errorf("Expected tokens at the limits to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"runtime"
+)
+
+// A MetadataPolicy decides how a Decoder handles metadata, written as ^meta
+// in front of the value it is attached to. Metadata is not part of the EDN
+// specification, but Clojure writes it when *print-meta* is true. The
+// metadata is a map, or one of the shorthands ^:key for {:key true} and
+// ^Symbol or ^"string" for {:tag Symbol} and {:tag "string"}. Multiple
+// metadata forms in front of a single value are merged, and keys in outer
+// forms take precedence.
+type MetadataPolicy int
+
+const (
+	// RejectMetadata returns a SyntaxError when metadata is found. This is the
+	// default.
+	RejectMetadata MetadataPolicy = iota
+	// DiscardMetadata skips metadata, so that only the values it is attached to
+	// are decoded.
+	DiscardMetadata
+	// KeepMetadata decodes metadata along with the value it is attached to. In
+	// an empty interface, the value is stored as a WithMeta. The metadata is
+	// also stored when decoding into a WithMeta, or into a struct with a field
+	// that has the "meta" option. It is dropped for all other destinations.
+	KeepMetadata
+
+	// passMetadata returns metadata tokens as is from nextToken. It is used by
+	// the pretty printers.
+	passMetadata
+)
+
+// UseMetadataPolicy sets the policy the decoder uses for metadata.
+func (d *Decoder) UseMetadataPolicy(policy MetadataPolicy) {
+	d.metadata = policy
+}
+
+// A WithMeta is a value with metadata. When the decoder keeps metadata (see
+// KeepMetadata), values with metadata decode into a WithMeta when decoding
+// into an empty interface. Decoding into a WithMeta always stores the value in
+// Value, and the metadata, if any, in Meta.
+//
+// A WithMeta encodes as its Value, preceded by ^ and its Meta if Meta is not
+// empty.
+type WithMeta struct {
+	Meta  map[interface{}]interface{}
+	Value interface{}
+}
+
+var withMetaType = reflect.TypeOf(WithMeta{})
+
+func isWithMeta(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == withMetaType
+}
+
+// metaToken handles the metadata following a ^ token according to the
+// metadata policy, and returns the token starting the value the metadata is
+// attached to. When metadata is kept, it is stored in d.meta until the value
+// is decoded.
+func (d *Decoder) metaToken() (bs []byte, tt tokenType, err error) {
+	switch d.metadata {
+	case RejectMetadata:
+		return nil, tokenError, d.metaError(d.tokenPos, "unexpected metadata")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			bs, tt, err = nil, tokenError, r.(error)
+		}
+	}()
+	pos := d.tokenPos
+	var meta map[interface{}]interface{}
+	d.enter()
+	if d.metadata == KeepMetadata {
+		meta = d.metaMap(d.valueInterface(), pos)
+	} else if err := d.skipMeta(pos); err != nil {
+		d.depth--
+		return nil, tokenError, err
+	}
+	d.depth--
+	bs, tt, err = d.nextToken()
+	if err != nil {
+		return nil, tokenError, err
+	}
+	switch tt {
+	case tokenListEnd, tokenVectorEnd, tokenMapEnd:
+		return nil, tokenError, errUnexpected
+	}
+	if meta != nil {
+		// d.meta holds the metadata of the value, if it has more
+		if d.meta != nil {
+			for k, v := range meta {
+				d.meta[k] = v
+			}
+		} else {
+			d.meta = meta
+		}
+	}
+	return bs, tt, nil
+}
+
+// metaMap returns the metadata m found at pos as a map, expanding the
+// shorthand forms.
+func (d *Decoder) metaMap(m interface{}, pos Position) map[interface{}]interface{} {
+	switch m := m.(type) {
+	case map[interface{}]interface{}:
+		return m
+	case Keyword:
+		return map[interface{}]interface{}{m: true}
+	case Symbol, string:
+		return map[interface{}]interface{}{Keyword("tag"): m}
+	case WithMeta:
+		// metadata on the metadata itself is dropped
+		return d.metaMap(m.Value, pos)
+	}
+	d.error(d.metaError(pos, errMetaType))
+	return nil
+}
+
+// skipMeta skips the metadata found at pos, after checking that it is one of
+// the forms metaMap accepts.
+func (d *Decoder) skipMeta(pos Position) error {
+	bs, tt, err := d.nextToken()
+	if err != nil {
+		return err
+	}
+	switch tt {
+	case tokenMapStart, tokenKeyword, tokenString:
+	case tokenSymbol:
+		if bytes.Equal(bs, nilByte) || bytes.Equal(bs, trueByte) || bytes.Equal(bs, falseByte) {
+			return d.metaError(pos, errMetaType)
+		}
+	default:
+		return d.metaError(pos, errMetaType)
+	}
+	d.doUndo(bs, tt)
+	return d.traverseValue()
+}
+
+const errMetaType = "metadata must be a map, keyword, symbol or string"
+
+func (d *Decoder) metaError(pos Position, msg string) error {
+	return &SyntaxError{
+		msg:      msg,
+		Position: pos,
+		Excerpt:  d.excerpt(pos),
+	}
+}
+
+// valueMeta decodes the value starting with the token bs into v, along with
+// the pending metadata in d.meta. It returns false if v cannot hold the
+// metadata, in which case the metadata is dropped and the caller must decode
+// the value as usual.
+func (d *Decoder) valueMeta(bs []byte, tt tokenType, v reflect.Value) bool {
+	meta := d.meta
+	d.meta = nil
+	if tt == tokenSymbol && bytes.Equal(bs, nilByte) {
+		return false
+	}
+	u, pv := d.indirect(v, false)
+	if u != nil {
+		return false
+	}
+	switch {
+	case pv.Type() == withMetaType:
+		pv.Field(0).Set(reflect.ValueOf(meta))
+		d.doUndo(bs, tt)
+		d.value(pv.Field(1))
+	case pv.Kind() == reflect.Interface && pv.NumMethod() == 0:
+		d.doUndo(bs, tt)
+		pv.Set(reflect.ValueOf(WithMeta{Meta: meta, Value: d.valueInterface()}))
+	case pv.Kind() == reflect.Struct:
+		f := metaField(cachedTypeFields(pv.Type(), d.naming))
+		if f == nil {
+			return false
+		}
+		d.doUndo(bs, tt)
+		d.value(pv)
+		d.setMeta(pv, f, meta)
+	default:
+		return false
+	}
+	return true
+}
+
+// metaField returns the field with the "meta" option, or nil if there is
+// none.
+func metaField(fields []field) *field {
+	for i := range fields {
+		if fields[i].meta {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// setMeta stores meta in the meta field f of the struct v. If the field
+// cannot hold the map directly, the map is decoded into it.
+func (d *Decoder) setMeta(v reflect.Value, f *field, meta map[interface{}]interface{}) {
+	fv, fieldName := allocFieldByIndex(v, f.index)
+	mv := reflect.ValueOf(meta)
+	if mv.Type().AssignableTo(fv.Type()) {
+		fv.Set(mv)
+		return
+	}
+	bs, err := Marshal(meta)
+	if err == nil {
+		err = d.withInput(bs).Decode(fv.Addr().Interface())
+	}
+	if err != nil {
+		d.typeError(fmt.Errorf("edn: cannot decode metadata into field %s of %s: %v", fieldName, v.Type(), err))
+	}
+}
+
+func withMetaEncoder(e *encodeState, v reflect.Value) {
+	w := v.Interface().(WithMeta)
+	e.writeMeta(reflect.ValueOf(w.Meta))
+	e.reflectValue(reflect.ValueOf(w.Value))
+}
+
+// writeMeta writes ^ followed by the metadata m, unless m is empty or the
+// encodeState ignores metadata.
+func (e *encodeState) writeMeta(m reflect.Value) {
+	if e.noMeta || !m.IsValid() || isEmptyValue(m) {
+		return
+	}
+	e.ensureDelim()
+	e.WriteByte('^')
+	e.needsDelim = false
+	e.reflectValue(m)
+	e.needsDelim = true
+}
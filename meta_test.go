@@ -0,0 +1,211 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type metaDoc struct {
+	Name string
+	Meta map[interface{}]interface{} `edn:",meta"`
+}
+
+type metaInfo struct {
+	Doc     string
+	Private bool
+}
+
+type metaInfoDoc struct {
+	Name string
+	Info metaInfo `edn:",meta"`
+}
+
+func TestMetadataPolicy(t *testing.T) {
+	const input = `[^:a 1 ^{:b 2} [x] ^String y ^:a ^{:a 2 :c 3} z]`
+	var val interface{}
+	err := UnmarshalString(input, &val)
+	if serr, ok := err.(*SyntaxError); !ok || serr.Position.Column != 2 || serr.Excerpt == "" {
+		t.Errorf("Expected syntax error with excerpt at column 2 by default, got %v", err)
+	}
+
+	d := NewDecoder(strings.NewReader(input))
+	d.UseMetadataPolicy(DiscardMetadata)
+	if err := d.Decode(&val); err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{int64(1), []interface{}{Symbol("x")}, Symbol("y"), Symbol("z")}
+	if !reflect.DeepEqual(val, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, val)
+	}
+
+	val = nil
+	d = NewDecoder(strings.NewReader(input))
+	d.UseMetadataPolicy(KeepMetadata)
+	if err := d.Decode(&val); err != nil {
+		t.Fatal(err)
+	}
+	expected = []interface{}{
+		WithMeta{map[interface{}]interface{}{Keyword("a"): true}, int64(1)},
+		WithMeta{map[interface{}]interface{}{Keyword("b"): int64(2)}, []interface{}{Symbol("x")}},
+		WithMeta{map[interface{}]interface{}{Keyword("tag"): Symbol("String")}, Symbol("y")},
+		// outer metadata takes precedence
+		WithMeta{map[interface{}]interface{}{Keyword("a"): true, Keyword("c"): int64(3)}, Symbol("z")},
+	}
+	if !reflect.DeepEqual(val, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, val)
+	}
+
+	// both policies accept the same syntax
+	for _, policy := range []MetadataPolicy{DiscardMetadata, KeepMetadata} {
+		d = NewDecoder(strings.NewReader(`^^:a {:b 1} x`))
+		d.UseMetadataPolicy(policy)
+		if err := d.Decode(&val); err != nil {
+			t.Errorf("Expected metadata on metadata to be accepted with policy %d, got %v", policy, err)
+		}
+		for _, input := range []string{`[^:a]`, `^1 x`, `^:a`, `^nil x`, `^[:a] x`, `^#foo {} x`} {
+			d = NewDecoder(strings.NewReader(input))
+			d.UseMetadataPolicy(policy)
+			if err := d.Decode(&val); err == nil {
+				t.Errorf("Expected error for %s with policy %d", input, policy)
+			}
+		}
+	}
+}
+
+func TestMetadataDestinations(t *testing.T) {
+	d := NewDecoder(strings.NewReader(`^{:doc "x"} {:name "a"} ^:private ^{:doc "y"} {:name "b"} ^:a [1] 2 ^:b 3`))
+	d.UseMetadataPolicy(KeepMetadata)
+
+	var doc metaDoc
+	if err := d.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Name != "a" || !reflect.DeepEqual(doc.Meta, map[interface{}]interface{}{Keyword("doc"): "x"}) {
+		t.Errorf("Expected metadata in meta field, got %#v", doc)
+	}
+
+	var info metaInfoDoc
+	if err := d.Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "b" || info.Info != (metaInfo{Doc: "y", Private: true}) {
+		t.Errorf("Expected metadata decoded into meta field, got %#v", info)
+	}
+
+	var w WithMeta
+	if err := d.Decode(&w); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(w, WithMeta{map[interface{}]interface{}{Keyword("a"): true}, []interface{}{int64(1)}}) {
+		t.Errorf("Expected WithMeta with metadata, got %#v", w)
+	}
+	if err := d.Decode(&w); err != nil {
+		t.Fatal(err)
+	}
+	if w.Meta != nil || w.Value != int64(2) {
+		t.Errorf("Expected WithMeta without metadata, got %#v", w)
+	}
+
+	// other destinations drop the metadata
+	var n int
+	if err := d.Decode(&n); err != nil || n != 3 {
+		t.Errorf("Expected 3, got %d (%v)", n, err)
+	}
+}
+
+func TestEncodeMetadata(t *testing.T) {
+	val := []interface{}{
+		WithMeta{map[interface{}]interface{}{Keyword("a"): true}, Symbol("x")},
+		WithMeta{Value: []int{1}},
+		metaDoc{Name: "a", Meta: map[interface{}]interface{}{Keyword("doc"): "x"}},
+		metaDoc{Name: "b"},
+	}
+	bs, err := Marshal(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[^{:a true} x[1]^{:doc"x"}{:name"a"}{:name"b"}]`
+	if string(bs) != expected {
+		t.Errorf("Expected %s, got %s", expected, bs)
+	}
+
+	bs, err = Marshal(val[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(bytes.NewReader(bs))
+	d.UseMetadataPolicy(KeepMetadata)
+	var doc metaDoc
+	if err := d.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(doc, val[2]) {
+		t.Errorf("Expected %#v to round trip, got %#v", val[2], doc)
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.BeginVector()
+	w.Symbol("x")
+	w.Meta()
+	w.Keyword("private")
+	w.Symbol("y")
+	if err := w.EndVector(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[x ^:private y]\n"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+	w.BeginVector()
+	w.Meta()
+	w.Keyword("private")
+	if err := w.EndVector(); err != ErrDanglingMeta {
+		t.Errorf("Expected ErrDanglingMeta, got %v", err)
+	}
+
+	if !Equal(val[0], Symbol("x")) {
+		t.Errorf("Expected metadata to be ignored by Equal")
+	}
+}
+
+func TestFormatMetadata(t *testing.T) {
+	const input = `[x ^:a  y {^:k :key ^{:v 1} val} ^:a ^:b #foo 1]`
+	var buf bytes.Buffer
+	if err := Compact(&buf, []byte(input)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `[x ^:a y{^:k :key ^{:v 1}val}^:a ^:b #foo 1]`; buf.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := Indent(&buf, []byte(input), "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	expected := "[\n  x\n  ^:a y\n  {\n    ^:k :key ^{:v 1} val\n  }\n  ^:a ^:b #foo 1\n]"
+	if buf.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := PPrint(&buf, []byte(input), nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `[x ^:a y {^:k :key ^{:v 1} val} ^:a ^:b #foo 1]`; buf.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := PPrint(&buf, []byte(`^{:doc "a long docstring"} [1 2 3]`), &PPrintOpts{RightMargin: 30}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "^{:doc \"a long docstring\"}\n[1 2 3]"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"reflect"
+	"sync"
+	"unicode"
+)
+
+// A NamingStrategy derives the EDN key of a struct field from the name of the
+// Go field. It is only used for fields without a name in their struct tag.
+type NamingStrategy struct {
+	fn func(string) string
+
+	// The fields and encoders of the struct types named by this strategy are
+	// cached here, so that they are freed along with the strategy.
+	mu       sync.RWMutex
+	useJSON  bool // the value of canUseJSONTag when the cache was filled
+	fields   map[reflect.Type][]field
+	encoders map[reflect.Type]*structEncoder
+}
+
+// NewNamingStrategy returns a NamingStrategy which names fields with fn. fn is
+// called with the name of the Go field, and must return a valid keyword name.
+func NewNamingStrategy(fn func(fieldName string) string) *NamingStrategy {
+	return &NamingStrategy{fn: fn}
+}
+
+var (
+	// LowerFirst lower-cases the first letter of the field name, e.g. GroupID
+	// becomes :groupID. This is the default naming strategy.
+	LowerFirst = NewNamingStrategy(lowerFirst)
+	// KebabCase separates the words of the field name by dashes, e.g. GroupID
+	// becomes :group-id.
+	KebabCase = NewNamingStrategy(func(s string) string { return joinWords(s, '-') })
+	// SnakeCase separates the words of the field name by underscores, e.g.
+	// GroupID becomes :group_id.
+	SnakeCase = NewNamingStrategy(func(s string) string { return joinWords(s, '_') })
+	// Verbatim uses the field name as is, e.g. GroupID becomes :GroupID.
+	Verbatim = NewNamingStrategy(func(s string) string { return s })
+)
+
+// A FieldNamer is a struct type which chooses the naming strategy of its own
+// fields, regardless of the strategy used by the Encoder or Decoder. The
+// method is called on the zero value of the type.
+type FieldNamer interface {
+	EDNFieldNaming() *NamingStrategy
+}
+
+// UseNamingStrategy sets the naming strategy the encoder uses for struct
+// fields, unless the struct type is a FieldNamer.
+func (e *Encoder) UseNamingStrategy(ns *NamingStrategy) {
+	e.ec.naming = ns
+}
+
+// UseNamingStrategy sets the naming strategy the decoder uses to match keys to
+// struct fields, unless the struct type is a FieldNamer.
+func (d *Decoder) UseNamingStrategy(ns *NamingStrategy) {
+	d.naming = ns
+}
+
+var fieldNamerType = reflect.TypeOf((*FieldNamer)(nil)).Elem()
+
+// typeNaming returns the naming strategy of the struct type t, or ns if t is
+// not a FieldNamer.
+func typeNaming(t reflect.Type, ns *NamingStrategy) *NamingStrategy {
+	var fn FieldNamer
+	switch {
+	case t.Implements(fieldNamerType):
+		fn = reflect.Zero(t).Interface().(FieldNamer)
+	case reflect.PtrTo(t).Implements(fieldNamerType):
+		fn = reflect.New(t).Interface().(FieldNamer)
+	}
+	if fn != nil {
+		if tns := fn.EDNFieldNaming(); tns != nil {
+			return tns
+		}
+	}
+	if ns == nil {
+		return LowerFirst
+	}
+	return ns
+}
+
+// typeFields is like typeFields, but caches the fields of t in ns.
+func (ns *NamingStrategy) typeFields(t reflect.Type) []field {
+	useJSON := readCanUseJSONTag()
+	ns.mu.RLock()
+	f := ns.fields[t]
+	valid := ns.useJSON == useJSON
+	ns.mu.RUnlock()
+	if f != nil && valid {
+		return f
+	}
+	f = typeFields(t, ns)
+	if f == nil {
+		f = []field{}
+	}
+	ns.mu.Lock()
+	if useJSON != readCanUseJSONTag() {
+		// cache has been invalidated, unlock and retry recursively.
+		ns.mu.Unlock()
+		return ns.typeFields(t)
+	}
+	ns.reset(useJSON)
+	ns.fields[t] = f
+	ns.mu.Unlock()
+	return f
+}
+
+// structEncoder returns the encoder for the struct type t with its fields
+// named by ns.
+func (ns *NamingStrategy) structEncoder(t reflect.Type) *structEncoder {
+	useJSON := readCanUseJSONTag()
+	ns.mu.RLock()
+	se := ns.encoders[t]
+	valid := ns.useJSON == useJSON
+	ns.mu.RUnlock()
+	if se != nil && valid {
+		return se
+	}
+	se = makeStructEncoder(t, ns)
+	ns.mu.Lock()
+	if useJSON != readCanUseJSONTag() {
+		ns.mu.Unlock()
+		return ns.structEncoder(t)
+	}
+	ns.reset(useJSON)
+	ns.encoders[t] = se
+	ns.mu.Unlock()
+	return se
+}
+
+// reset empties the caches of ns if they were filled with another value of
+// canUseJSONTag. ns.mu must be held.
+func (ns *NamingStrategy) reset(useJSON bool) {
+	if ns.fields == nil || ns.useJSON != useJSON {
+		ns.fields = map[reflect.Type][]field{}
+		ns.encoders = map[reflect.Type]*structEncoder{}
+		ns.useJSON = useJSON
+	}
+}
+
+func lowerFirst(s string) string {
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+// joinWords splits the Go identifier s into lower-cased words and joins them
+// with sep. A new word starts at an upper-case letter following a lower-case
+// letter or a digit, and at the last upper-case letter of an acronym, e.g.
+// HTTPServer becomes http-server. Underscores are replaced by sep.
+func joinWords(s string, sep rune) string {
+	rs := []rune(s)
+	buf := make([]rune, 0, len(rs)+4)
+	for i, r := range rs {
+		atSep := len(buf) == 0 || buf[len(buf)-1] == sep
+		if r == '_' {
+			if !atSep {
+				buf = append(buf, sep)
+			}
+			continue
+		}
+		if unicode.IsUpper(r) && !atSep {
+			prev := rs[i-1]
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+				unicode.IsUpper(prev) && i+1 < len(rs) && unicode.IsLower(rs[i+1]) {
+				buf = append(buf, sep)
+			}
+		}
+		buf = append(buf, unicode.ToLower(r))
+	}
+	return string(buf)
+}
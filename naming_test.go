@@ -0,0 +1,107 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJoinWords(t *testing.T) {
+	tests := map[string]string{
+		"Name":       "name",
+		"GroupID":    "group-id",
+		"HTTPServer": "http-server",
+		"UserID2":    "user-id2",
+		"Base64Data": "base64-data",
+		"ID":         "id",
+		"Foo_Bar":    "foo-bar",
+		"ÆrøSkål":    "ærø-skål",
+	}
+	for in, expected := range tests {
+		if out := joinWords(in, '-'); out != expected {
+			t.Errorf("Expected %s to become %s, got %s", in, expected, out)
+		}
+	}
+}
+
+type kebabStruct struct {
+	UserID   int
+	FullName string `edn:"name"`
+}
+
+func (kebabStruct) EDNFieldNaming() *NamingStrategy {
+	return KebabCase
+}
+
+func TestNamingStrategies(t *testing.T) {
+	type Inner struct {
+		MaxConns int
+	}
+	type Config struct {
+		GroupID string
+		Inner
+		Other  kebabStruct
+		Server string `edn:"srv"`
+	}
+	cfg := Config{"g", Inner{10}, kebabStruct{1, "Alice"}, "s"}
+	tests := []struct {
+		ns       *NamingStrategy
+		expected string
+	}{
+		{nil, `{:groupID"g":maxConns 10 :other{:user-id 1 :name"Alice"}:srv"s"}`},
+		{KebabCase, `{:group-id"g":max-conns 10 :other{:user-id 1 :name"Alice"}:srv"s"}`},
+		{SnakeCase, `{:group_id"g":max_conns 10 :other{:user-id 1 :name"Alice"}:srv"s"}`},
+		{Verbatim, `{:GroupID"g":MaxConns 10 :Other{:user-id 1 :name"Alice"}:srv"s"}`},
+		{NewNamingStrategy(strings.ToUpper),
+			`{:GROUPID"g":MAXCONNS 10 :OTHER{:user-id 1 :name"Alice"}:srv"s"}`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.UseNamingStrategy(test.ns)
+		if err := enc.Encode(cfg); err != nil {
+			t.Fatal(err)
+		}
+		if out := strings.TrimSpace(buf.String()); out != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, out)
+		}
+
+		var res Config
+		dec := NewDecoder(&buf)
+		dec.UseNamingStrategy(test.ns)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if res != cfg {
+			t.Errorf("Expected %s to decode into %+v, got %+v", test.expected, cfg, res)
+		}
+	}
+}
+
+func TestNamingStrategyCache(t *testing.T) {
+	type Doc struct {
+		DocID int
+	}
+	ns := NewNamingStrategy(strings.ToUpper)
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.UseNamingStrategy(ns)
+		if err := e.Encode(Doc{1}); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "{:DOCID 1}\n" {
+			t.Errorf("Expected {:DOCID 1}, got %q", buf.String())
+		}
+	}
+	typ := reflect.TypeOf(Doc{})
+	if len(ns.encoders) != 1 || ns.encoders[typ] == nil || ns.structEncoder(typ) != ns.encoders[typ] {
+		t.Errorf("Expected the encoder to be cached by the naming strategy, got %v", ns.encoders)
+	}
+}
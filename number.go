@@ -0,0 +1,105 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A Number is an EDN number literal as it was written in the input, including
+// any N or M suffix, e.g. 12345678901234567890, 1.10M or 22/7. Integers,
+// floats, ratios and the symbolic values ##Inf, ##-Inf and ##NaN are numbers.
+//
+// EDN numbers can always be decoded into a Number, and a Decoder can decode
+// numbers into Numbers when decoding into an empty interface, see
+// Decoder.UseNumber. A Number encodes as the literal it contains, so numbers
+// pass through a decode and encode round trip unchanged.
+type Number string
+
+// String returns the literal text of the number.
+func (n Number) String() string {
+	return string(n)
+}
+
+// Int64 returns the number as an int64. It returns an error if the number is
+// not an integer, or does not fit in an int64.
+func (n Number) Int64() (int64, error) {
+	return strconv.ParseInt(strings.TrimSuffix(string(n), "N"), 10, 64)
+}
+
+// BigInt returns the number as a big.Int. It returns an error if the number is
+// not an integer.
+func (n Number) BigInt() (*big.Int, error) {
+	bi, ok := new(big.Int).SetString(strings.TrimSuffix(string(n), "N"), 10)
+	if !ok {
+		return nil, &UnmarshalTypeError{Value: "number " + string(n), Type: bigIntType}
+	}
+	return bi, nil
+}
+
+// Float64 returns the number as a float64, rounded to the nearest float64 if
+// it cannot be represented exactly.
+func (n Number) Float64() (float64, error) {
+	if n.isRatio() {
+		r, ok := new(big.Rat).SetString(string(n))
+		if !ok {
+			return 0, &UnmarshalTypeError{Value: "number " + string(n), Type: reflect.TypeOf(float64(0))}
+		}
+		f, _ := r.Float64()
+		return f, nil
+	}
+	if n == "" {
+		return strconv.ParseFloat("", 64)
+	}
+	return strconv.ParseFloat(floatString([]byte(n)), 64)
+}
+
+// BigFloat returns the number as a big.Float with the precision and rounding
+// mode of mc. It returns an error if the number is ##NaN.
+func (n Number) BigFloat(mc MathContext) (*big.Float, error) {
+	bf := new(big.Float).SetPrec(mc.Precision).SetMode(mc.Mode)
+	if n.isRatio() {
+		if r, ok := new(big.Rat).SetString(string(n)); ok {
+			return bf.SetRat(r), nil
+		}
+	} else if n != "" {
+		if _, _, err := bf.Parse(floatString([]byte(n)), 10); err == nil {
+			return bf, nil
+		}
+	}
+	return nil, &UnmarshalTypeError{Value: "number " + string(n), Type: bigFloatType}
+}
+
+func (n Number) isRatio() bool {
+	return strings.IndexByte(string(n), '/') >= 0
+}
+
+// MarshalEDN returns the literal text of n. The empty Number encodes as 0. If
+// n is not a valid EDN number, MarshalEDN returns an error.
+func (n Number) MarshalEDN() ([]byte, error) {
+	if n == "" {
+		return []byte("0"), nil
+	}
+	if tt, ok := singleToken(string(n)); !ok || !isNumberToken(tt) {
+		return nil, &UnsupportedValueError{reflect.ValueOf(n), "invalid number " + strconv.Quote(string(n))}
+	}
+	return []byte(n), nil
+}
+
+// UseNumber makes the decoder decode numbers into an empty interface as a
+// Number instead of as an int64, float64 or one of the big number types. This
+// takes precedence over Decoder.UseDecimals.
+func (d *Decoder) UseNumber() {
+	d.useNumber = true
+}
+
+var numberType = reflect.TypeOf(Number(""))
+
+func isNumberToken(tt tokenType) bool {
+	return tt == tokenInt || tt == tokenFloat || tt == tokenRatio
+}
@@ -0,0 +1,86 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestUseNumber(t *testing.T) {
+	input := `{:id 12345678901234567890 :n 5N :price 1.10M :ratio 22/7 :max ##Inf :x 1.5e3}`
+	var val interface{}
+	d := NewDecoder(strings.NewReader(input))
+	d.UseNumber()
+	if err := d.Decode(&val); err != nil {
+		t.Fatal(err)
+	}
+	m := val.(map[interface{}]interface{})
+	expected := map[Keyword]Number{
+		"id": "12345678901234567890", "n": "5N", "price": "1.10M",
+		"ratio": "22/7", "max": "##Inf", "x": "1.5e3",
+	}
+	for k, n := range expected {
+		if m[k] != n {
+			t.Errorf("Expected %s to be Number %s, got %#v", k, n, m[k])
+		}
+	}
+	bs, err := MarshalSorted(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != `{:id 12345678901234567890,:max ##Inf,:n 5N,:price 1.10M,:ratio 22/7,:x 1.5e3}` {
+		t.Errorf("Expected numbers to round trip unchanged, got %s", bs)
+	}
+
+	// Number fields do not need UseNumber
+	var s struct{ Amount, Name Number }
+	if err := UnmarshalString(`{:amount 1.0M}`, &s); err != nil || s.Amount != "1.0M" {
+		t.Errorf("Expected Number field to be decoded, got %q (err: %v)", s.Amount, err)
+	}
+	if err := UnmarshalString(`{:name "1"}`, &s); err == nil {
+		t.Errorf("Expected error when decoding string into Number")
+	}
+	if bs, err := Marshal(s); err != nil || string(bs) != `{:amount 1.0M :name 0}` {
+		t.Errorf("Expected empty Number to encode as 0, got %s (err: %v)", bs, err)
+	}
+	if _, err := Marshal(Number("1.0.0")); err == nil {
+		t.Errorf("Expected error when encoding invalid Number")
+	}
+}
+
+func TestNumberAccessors(t *testing.T) {
+	if n, err := Number("-42").Int64(); err != nil || n != -42 {
+		t.Errorf("Expected -42, got %d (err: %v)", n, err)
+	}
+	if _, err := Number("12345678901234567890").Int64(); err == nil {
+		t.Errorf("Expected error for Int64 overflow")
+	}
+	if _, err := Number("1.5").Int64(); err == nil {
+		t.Errorf("Expected error for Int64 of float")
+	}
+	if bi, err := Number("12345678901234567890N").BigInt(); err != nil || bi.String() != "12345678901234567890" {
+		t.Errorf("Expected big integer, got %v (err: %v)", bi, err)
+	}
+	floats := map[Number]float64{"1.5M": 1.5, "7N": 7, "1/4": 0.25, "##-Inf": math.Inf(-1), "1e3": 1000}
+	for n, expected := range floats {
+		if f, err := n.Float64(); err != nil || f != expected {
+			t.Errorf("Expected %s to be %v, got %v (err: %v)", n, expected, f, err)
+		}
+	}
+	mc := MathContext{Precision: 256, Mode: big.ToNearestEven}
+	bf, err := Number("0.1M").BigFloat(mc)
+	if err != nil || bf.Prec() != 256 || bf.Text('g', 20) != "0.1" {
+		t.Errorf("Expected 0.1 with precision 256, got %v (err: %v)", bf, err)
+	}
+	if bf, err := Number("1/3").BigFloat(mc); err != nil || bf.Text('g', 5) != "0.33333" {
+		t.Errorf("Expected 1/3, got %v (err: %v)", bf, err)
+	}
+	if _, err := Number("##NaN").BigFloat(mc); err == nil {
+		t.Errorf("Expected error for NaN big.Float")
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bufio"
+	"bytes"
+	"math/big"
+	"reflect"
+	"sort"
+)
+
+// Equal reports whether a and b are the same EDN value. The values are
+// compared by their EDN encoding, so that maps are equal if they have equal
+// entries, sets are equal if they have equal elements regardless of order, and
+// Values, pointers and the values they point to are interchangeable. NaN is
+// equal to itself, and metadata is ignored. Integers are never equal to
+// floats, but integers with and without the N suffix are equal if they have
+// the same value, as in Clojure. Lists and vectors are distinct, but note that
+// the Decoder decodes lists into slices unless it uses collection types.
+//
+// If a or b cannot be encoded as EDN, Equal falls back to reflect.DeepEqual.
+func Equal(a, b interface{}) bool {
+	va, erra := NewValue(a)
+	vb, errb := NewValue(b)
+	if erra != nil || errb != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return va == vb
+}
+
+// Compare returns an integer comparing a and b in a total order over EDN
+// values. The result is 0 if Equal(a, b), -1 if a sorts before b and +1 if b
+// sorts before a. This is the order MarshalSorted uses for map entries and set
+// elements: values of different kinds are ordered nil, booleans, numbers,
+// characters, strings, symbols, keywords, tagged values, lists, vectors, maps
+// and sets. Numbers are ordered by numeric value, with ##-Inf first and
+// ##Inf and ##NaN last, characters by code point, and collections element by
+// element.
+//
+// Compare panics if a or b cannot be encoded as EDN.
+func Compare(a, b interface{}) int {
+	va, err := NewValue(a)
+	if err != nil {
+		panic(err)
+	}
+	vb, err := NewValue(b)
+	if err != nil {
+		panic(err)
+	}
+	if va == vb {
+		return 0
+	}
+	return compareEncoded([]byte(va.String()), []byte(vb.String()))
+}
+
+// compareEncoded compares two EDN-encoded values, returning -1 if a sorts
+// before b, 1 if b sorts before a, and 0 if they are identical.
+//
+// The values are compared token by token. Tokens of different kinds are
+// ordered by rank: collection ends (so that shorter collections come first),
+// nil, booleans, numbers, characters, strings, symbols, keywords, tags, lists,
+// vectors, maps and sets. Numbers are compared by numeric value regardless of
+// whether they are integers or floats, with integers sorting before floats of
+// equal value. Characters are compared by code point, and the remaining tokens
+// are compared lexicographically. Ties are broken by comparing the encodings
+// byte by byte.
+func compareEncoded(a, b []byte) int {
+	return compareOrderTokens(a, orderTokens(a), b, orderTokens(b))
+}
+
+// An orderToken is a token of an EDN-encoded value, prepared for comparison.
+type orderToken struct {
+	bs  []byte
+	tt  tokenType
+	num *big.Rat // the exact value of finite numbers, otherwise nil
+}
+
+// orderTokens splits the EDN-encoded value bs into tokens. The tokens end at
+// the end of bs, or at the first token that cannot be read.
+func orderTokens(bs []byte) []orderToken {
+	d := newDecoder(bufio.NewReader(bytes.NewReader(bs)))
+	var toks []orderToken
+	for {
+		tbs, tt, err := d.nextToken()
+		if err != nil {
+			return toks
+		}
+		tok := orderToken{bs: tbs, tt: tt}
+		switch tt {
+		case tokenInt, tokenFloat, tokenRatio:
+			if r, ok := numberRat(tbs); ok {
+				tok.num = r
+			}
+		}
+		toks = append(toks, tok)
+	}
+}
+
+// compareOrderTokens is compareEncoded for a and b split into the tokens ta
+// and tb.
+func compareOrderTokens(a []byte, ta []orderToken, b []byte, tb []orderToken) int {
+	for i := 0; ; i++ {
+		switch {
+		case i == len(ta) && i == len(tb):
+			return bytes.Compare(a, b)
+		case i == len(ta):
+			return -1
+		case i == len(tb):
+			return 1
+		}
+		if c := compareTokens(&ta[i], &tb[i]); c != 0 {
+			return c
+		}
+	}
+}
+
+func tokenRank(bs []byte, tt tokenType) int {
+	switch tt {
+	case tokenListEnd, tokenVectorEnd, tokenMapEnd:
+		return 0
+	case tokenSymbol:
+		switch {
+		case bytes.Equal(bs, nilByte):
+			return 1
+		case bytes.Equal(bs, falseByte), bytes.Equal(bs, trueByte):
+			return 2
+		}
+		return 6
+	case tokenInt, tokenFloat, tokenRatio:
+		return 3
+	case tokenChar:
+		return 4
+	case tokenString:
+		return 5
+	case tokenKeyword:
+		return 7
+	case tokenTag:
+		return 8
+	case tokenListStart:
+		return 9
+	case tokenVectorStart:
+		return 10
+	case tokenMapStart:
+		return 11
+	case tokenSetStart:
+		return 12
+	}
+	return 13
+}
+
+func compareTokens(a, b *orderToken) int {
+	bsa, tta, bsb, ttb := a.bs, a.tt, b.bs, b.tt
+	ra, rb := tokenRank(bsa, tta), tokenRank(bsb, ttb)
+	switch {
+	case ra < rb:
+		return -1
+	case ra > rb:
+		return 1
+	}
+	switch tta {
+	case tokenInt, tokenFloat, tokenRatio:
+		sa, sb := symbolicRank(bsa), symbolicRank(bsb)
+		switch {
+		case sa < sb:
+			return -1
+		case sa > sb:
+			return 1
+		}
+		if a.num != nil && b.num != nil {
+			if c := a.num.Cmp(b.num); c != 0 {
+				return c
+			}
+		}
+		switch {
+		case tta < ttb:
+			return -1
+		case tta > ttb:
+			return 1
+		}
+	case tokenChar:
+		ca, erra := toRune(bsa)
+		cb, errb := toRune(bsb)
+		if erra == nil && errb == nil {
+			switch {
+			case ca < cb:
+				return -1
+			case ca > cb:
+				return 1
+			}
+		}
+	case tokenString:
+		sa, oka := unquoteBytes(bsa)
+		sb, okb := unquoteBytes(bsb)
+		if oka && okb {
+			if c := bytes.Compare(sa, sb); c != 0 {
+				return c
+			}
+		}
+	}
+	return bytes.Compare(bsa, bsb)
+}
+
+// symbolicRank orders the symbolic values relative to other numbers: ##-Inf
+// sorts first, then all finite numbers, then ##Inf and finally ##NaN.
+func symbolicRank(bs []byte) int {
+	switch string(bs) {
+	case "##-Inf":
+		return -1
+	case "##Inf":
+		return 1
+	case "##NaN":
+		return 2
+	}
+	return 0
+}
+
+// numberRat returns the exact value of the numeric token bs.
+func numberRat(bs []byte) (*big.Rat, bool) {
+	if last := bs[len(bs)-1]; last == 'N' || last == 'M' {
+		bs = bs[:len(bs)-1]
+	}
+	return new(big.Rat).SetString(string(bs))
+}
+
+// byEncoding sorts reflect values by their EDN encoding. The encodings are
+// split into tokens once, up front.
+type byEncoding struct {
+	vals    []reflect.Value
+	encoded [][]byte
+	tokens  [][]orderToken
+}
+
+func (x byEncoding) Len() int { return len(x.vals) }
+
+func (x byEncoding) Swap(i, j int) {
+	x.vals[i], x.vals[j] = x.vals[j], x.vals[i]
+	x.encoded[i], x.encoded[j] = x.encoded[j], x.encoded[i]
+	x.tokens[i], x.tokens[j] = x.tokens[j], x.tokens[i]
+}
+
+func (x byEncoding) Less(i, j int) bool {
+	return compareOrderTokens(x.encoded[i], x.tokens[i], x.encoded[j], x.tokens[j]) < 0
+}
+
+// sortValues sorts vals in place by their EDN encoding, using enc to encode
+// them.
+func (e *encodeState) sortValues(vals []reflect.Value, enc encoderFunc) {
+	encoded := make([][]byte, len(vals))
+	tokens := make([][]orderToken, len(vals))
+	for i, v := range vals {
+		sub := e.sub()
+		enc(sub, v)
+		encoded[i] = sub.Bytes()
+		tokens[i] = orderTokens(encoded[i])
+	}
+	sort.Sort(byEncoding{vals, encoded, tokens})
+}
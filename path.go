@@ -0,0 +1,89 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A pathElem is a single step on the path from the top level value to the
+// value currently being decoded. Keys are kept as the raw bytes read from the
+// input, so that building a path is cheap unless an error is reported.
+type pathElem struct {
+	key    []byte       // map key or set element, nil for an index
+	index  int          // index into a vector or list
+	field  string       // name of the Go struct field, if any
+	strukt reflect.Type // type of the struct containing field
+}
+
+var pathEllipsis = []byte("...")
+
+// pushIndex records that the decoder descends into element i of a list or
+// vector.
+func (d *Decoder) pushIndex(i int) {
+	d.path = append(d.path, pathElem{index: i})
+}
+
+// pushKey records that the decoder descends into the map entry or set element
+// starting with the token bs. Collections are abbreviated.
+func (d *Decoder) pushKey(bs []byte, tt tokenType) {
+	switch tt {
+	case tokenSymbol, tokenKeyword, tokenString, tokenInt, tokenFloat, tokenRatio, tokenChar, tokenTag:
+	default:
+		bs = pathEllipsis
+	}
+	d.path = append(d.path, pathElem{key: bs})
+}
+
+// pushField records that the decoder descends into the struct field with the
+// key bs.
+func (d *Decoder) pushField(bs []byte, strukt reflect.Type, field string) {
+	d.path = append(d.path, pathElem{key: bs, field: field, strukt: strukt})
+}
+
+func (d *Decoder) popPath() {
+	d.path = d.path[:len(d.path)-1]
+}
+
+// pathString returns the current path as an EDN vector, e.g.
+// [:db :pool 2 :timeout], or the empty string at the top level.
+func (d *Decoder) pathString() string {
+	if len(d.path) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, elem := range d.path {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		if elem.key != nil {
+			buf.Write(elem.key)
+		} else {
+			buf.WriteString(strconv.Itoa(elem.index))
+		}
+	}
+	buf.WriteByte(']')
+	return buf.String()
+}
+
+// structField returns the name of the outermost struct type on the current
+// path, and the Go field names leading from it to the current value, joined by
+// dots.
+func (d *Decoder) structField() (strukt, field string) {
+	var fields []string
+	for _, elem := range d.path {
+		if elem.field != "" {
+			if fields == nil {
+				strukt = elem.strukt.Name()
+			}
+			fields = append(fields, elem.field)
+		}
+	}
+	return strukt, strings.Join(fields, ".")
+}
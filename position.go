@@ -0,0 +1,193 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Position is a location in EDN input.
+type Position struct {
+	Offset int64 // byte offset, starting at 0
+	Line   int   // line number, starting at 1
+	Column int   // column number in runes, starting at 1
+}
+
+// IsValid reports whether the position is known.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "unknown position"
+	}
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+// errorContext formats the value path, position and excerpt of an error, for
+// use as a suffix in an error message.
+func errorContext(path string, pos Position, excerpt string) string {
+	var parts []string
+	if path != "" {
+		parts = append(parts, "path "+path)
+	}
+	if pos.IsValid() {
+		if excerpt == "" {
+			parts = append(parts, pos.String())
+		} else {
+			parts = append(parts, fmt.Sprintf("%s, near %q", pos, excerpt))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " at " + strings.Join(parts, ", ")
+}
+
+const (
+	// excerptWidth is the maximal number of runes in an error excerpt
+	excerptWidth = 60
+	// maxLineBuf is the number of bytes of the current line the decoder retains
+	// for excerpts before discarding the start of it
+	maxLineBuf = 512
+)
+
+// readRune reads a rune from the underlying reader and updates the position of
+// the decoder. It returns a LimitError if the rune exceeds MaxBytes.
+func (d *Decoder) readRune() (rune, int, error) {
+	r, size, err := d.rd.ReadRune()
+	if err != nil {
+		return r, size, err
+	}
+	d.lastPos = d.pos
+	d.pos.Offset += int64(size)
+	if r == '\n' {
+		d.pos.Line++
+		d.pos.Column = 1
+		d.prevLine = append(d.prevLine[:0], d.lineBuf...)
+		d.prevLineCol = d.lineBufCol
+		d.lineBuf = d.lineBuf[:0]
+		d.lineBufCol = 1
+	} else {
+		d.pos.Column++
+		d.lineBuf = append(d.lineBuf, string(r)...)
+		if len(d.lineBuf) > maxLineBuf {
+			// drop the first half of the line, at a rune boundary
+			cut := len(d.lineBuf) / 2
+			for cut < len(d.lineBuf) && !utf8.RuneStart(d.lineBuf[cut]) {
+				cut++
+			}
+			d.lineBufCol += utf8.RuneCount(d.lineBuf[:cut])
+			d.lineBuf = append(d.lineBuf[:0], d.lineBuf[cut:]...)
+		}
+	}
+	if max := d.limits.MaxBytes; max > 0 && d.pos.Offset-d.bytesStart > max {
+		return r, size, d.limitError("MaxBytes", max, d.lastPos)
+	}
+	return r, size, nil
+}
+
+// excerpt returns a short part of the input line containing pos, or the empty
+// string if that line is no longer available.
+func (d *Decoder) excerpt(pos Position) string {
+	var line []byte
+	var lineCol int
+	switch pos.Line {
+	case d.pos.Line:
+		line = append(line, d.lineBuf...)
+		lineCol = d.lineBufCol
+		// Include the rest of the line if it is already buffered. We never read
+		// more input, as that could block.
+		if n := d.rd.Buffered(); n > 0 {
+			if n > excerptWidth*utf8.UTFMax {
+				n = excerptWidth * utf8.UTFMax
+			}
+			rest, _ := d.rd.Peek(n)
+			if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+				rest = rest[:i]
+			}
+			line = append(line, rest...)
+		}
+	case d.pos.Line - 1:
+		line = d.prevLine
+		lineCol = d.prevLineCol
+	default:
+		return ""
+	}
+	runes := bytes.Runes(bytes.TrimRight(line, "\r"))
+	start := pos.Column - lineCol - excerptWidth/2
+	if start < 0 {
+		start = 0
+	}
+	if start > len(runes) {
+		start = len(runes)
+	}
+	end := start + excerptWidth
+	if end > len(runes) {
+		end = len(runes)
+	}
+	return string(runes[start:end])
+}
+
+// positioned attaches position and path information to errors created while
+// decoding.
+// Errors which already have a position, and errors not created by the decoder,
+// are returned as is.
+func (d *Decoder) positioned(err error) error {
+	switch e := err.(type) {
+	case *SyntaxError:
+		if !e.IsValid() {
+			e.Position = d.lastPos
+			if !e.IsValid() { // nothing read yet
+				e.Position = d.pos
+			}
+			e.Excerpt = d.excerpt(e.Position)
+		}
+	case *UnmarshalTypeError:
+		if !e.IsValid() {
+			e.Position = d.tokenPos
+			e.Excerpt = d.excerpt(e.Position)
+			e.Path = d.pathString()
+			e.Struct, e.Field = d.structField()
+		}
+	case *UnknownFieldError:
+		if !e.IsValid() {
+			e.Position = d.tokenPos
+			e.Excerpt = d.excerpt(e.Position)
+			e.Path = d.pathString()
+		}
+	case UnknownTagError:
+		if !e.IsValid() {
+			e.Position = d.tokenPos
+			e.Excerpt = d.excerpt(e.Position)
+		}
+		return e
+	}
+	switch err {
+	case errUnexpected:
+		return &SyntaxError{
+			msg:      "unexpected token",
+			Position: d.tokenPos,
+			Excerpt:  d.excerpt(d.tokenPos),
+		}
+	case errNoneLeft:
+		return &SyntaxError{
+			msg:      "unexpected end of EDN input",
+			Position: d.pos,
+			Excerpt:  d.excerpt(d.pos),
+		}
+	case errIllegalRune:
+		return &SyntaxError{
+			msg:      "illegal character",
+			Position: d.tokenPos,
+			Excerpt:  d.excerpt(d.tokenPos),
+		}
+	}
+	return err
+}
@@ -49,6 +49,7 @@ func IndentStream(dst io.Writer, src io.Reader, prefix, indent string) error {
 	curType := tokenError
 	curSize := 0
 	d := NewDecoder(src)
+	d.metadata = passMetadata
 	depth := 0
 	for {
 		bs, tt, err := d.nextToken()
@@ -69,6 +70,10 @@ func IndentStream(dst io.Writer, src io.Reader, prefix, indent string) error {
 		case tokenMapStart, tokenVectorStart, tokenListStart, tokenSetStart:
 			if prevType == tokenMapStart {
 				dst.Write([]byte{' '})
+			} else if prevType == tokenMeta {
+				if prevSize == 1 { // the value after the metadata
+					dst.Write(spaceOutputBytes)
+				}
 			} else if depth > 0 {
 				newline(dst, prefix, indent, depth)
 			}
@@ -81,7 +86,7 @@ func IndentStream(dst io.Writer, src io.Reader, prefix, indent string) error {
 			}
 			// all of these are of length 1 in bytes, so utilise this for perf
 			dst.Write(bs)
-		case tokenTag:
+		case tokenTag, tokenMeta:
 			// need to know what the previous type was.
 			switch prevType {
 			case tokenMapStart:
@@ -94,14 +99,32 @@ func IndentStream(dst io.Writer, src io.Reader, prefix, indent string) error {
 					dst.Write(spaceOutputBytes)
 				}
 				dst.Write(bs)
-				dst.Write(spaceOutputBytes)
 			case tokenSetStart, tokenVectorStart, tokenListStart:
 				newline(dst, prefix, indent, depth)
 				dst.Write(bs)
-				dst.Write(spaceOutputBytes)
+			case tokenMeta:
+				if prevSize == 1 {
+					dst.Write(spaceOutputBytes)
+				}
+				dst.Write(bs)
 			default: // tokenError or nested tag
 				dst.Write(bs)
+			}
+			if tt == tokenTag {
 				dst.Write(spaceOutputBytes)
+			} else {
+				// write the metadata itself on a single line
+				meta, err := d.nextValueBytes()
+				if err != nil {
+					return err
+				}
+				var buf bytes.Buffer
+				if err := Compact(&buf, meta); err != nil {
+					return err
+				}
+				dst.Write(buf.Bytes())
+				tokStack.push(tokenSymbol)
+				curSize = 1
 			}
 		default:
 			switch prevType {
@@ -118,6 +141,11 @@ func IndentStream(dst io.Writer, src io.Reader, prefix, indent string) error {
 			case tokenSetStart, tokenVectorStart, tokenListStart:
 				newline(dst, prefix, indent, depth)
 				dst.Write(bs)
+			case tokenMeta:
+				if prevSize == 1 {
+					dst.Write(spaceOutputBytes)
+				}
+				dst.Write(bs)
 			default: // toplevel or nested tag. This should collapse the whole tag tower
 				dst.Write(bs)
 			}
@@ -129,13 +157,29 @@ func IndentStream(dst io.Writer, src io.Reader, prefix, indent string) error {
 	return nil
 }
 
-// PPrintOpts is a configuration map for PPrint. The values in this struct has
-// no effect as of now.
+// PPrintOpts is a configuration map for PPrint. A nil *PPrintOpts is
+// equivalent to a PPrintOpts with only zero values.
 type PPrintOpts struct {
+	// RightMargin is the column PPrint attempts to keep its output within.
+	// Collections that fit within the right margin are printed on a single line,
+	// and lists, vectors and sets that do not are filled: Elements are put on the
+	// same line until the next one would pass the margin. If zero,
+	// DefaultRightMargin is used.
 	RightMargin int
-	MiserWidth  int
+	// MiserWidth is the width, counting leftwards from the right margin, where
+	// PPrint switches to miser style: A collection that starts in this area and
+	// does not fit on the line is printed with every element on its own line, and
+	// map values that don't fit after their key are put on the next line. If
+	// zero, DefaultMiserWidth is used. A negative value turns miser style off.
+	MiserWidth int
 }
 
+// The default values PPrint uses for zero-valued PPrintOpts fields.
+const (
+	DefaultRightMargin = 72
+	DefaultMiserWidth  = 40
+)
+
 func pprintIndent(dst io.Writer, shift int) {
 	spaces := make([]byte, shift+1)
 
@@ -163,83 +207,230 @@ func PPrint(dst *bytes.Buffer, src []byte, opt *PPrintOpts) error {
 	return err
 }
 
-// PPrintStream is an implementation of PPrint for generic readers and writers
+// PPrintStream is an implementation of PPrint for generic readers and writers.
+// As PPrintStream needs to know the width of a value before it can print it,
+// it reads a complete value from src before writing anything to dst.
 func PPrintStream(dst io.Writer, src io.Reader, opt *PPrintOpts) error {
-	var lex lexer
-	var col, prevCollStart, curSize int
-	var prevColl bool
-
-	lex.reset()
-	tokStack := newTokenStack()
-
-	shift := make([]int, 1, 8) // pre-allocate some space
-	curType := tokenError
 	d := NewDecoder(src)
+	d.metadata = passMetadata
+	n, err := d.ppNode()
+	if err != nil {
+		return err
+	}
+	p := pprinter{
+		dst:    dst,
+		margin: DefaultRightMargin,
+		miser:  DefaultMiserWidth,
+	}
+	if opt != nil {
+		if opt.RightMargin != 0 {
+			p.margin = opt.RightMargin
+		}
+		if opt.MiserWidth != 0 {
+			p.miser = opt.MiserWidth
+		}
+	}
+	p.node(n)
+	return nil
+}
+
+// A ppNode is a single value read by PPrintStream. For collections, bs is the
+// opening delimiter, end the closing delimiter and elems the elements of the
+// collection. For tags, bs is the tag and elems contains the tagged value. For
+// metadata, bs is ^ and elems contains the metadata and the value.
+type ppNode struct {
+	bs    []byte
+	tt    tokenType
+	end   []byte
+	elems []*ppNode
+	width int // width of the value when printed on a single line
+}
 
+func (d *Decoder) ppNode() (*ppNode, error) {
+	bs, tt, err := d.nextToken()
+	if err != nil {
+		return nil, err
+	}
+	n := &ppNode{bs: bs, tt: tt, width: utf8.RuneCount(bs)}
+	var endType tokenType
+	switch tt {
+	case tokenTag:
+		elem, err := d.ppNode()
+		if err != nil {
+			return nil, err
+		}
+		n.elems = []*ppNode{elem}
+		n.width += 1 + elem.width
+		return n, nil
+	case tokenMeta:
+		meta, err := d.ppNode()
+		if err != nil {
+			return nil, err
+		}
+		elem, err := d.ppNode()
+		if err != nil {
+			return nil, err
+		}
+		n.elems = []*ppNode{meta, elem}
+		n.width += meta.width + 1 + elem.width
+		return n, nil
+	case tokenListStart:
+		endType = tokenListEnd
+	case tokenVectorStart:
+		endType = tokenVectorEnd
+	case tokenMapStart, tokenSetStart:
+		endType = tokenMapEnd
+	case tokenListEnd, tokenVectorEnd, tokenMapEnd:
+		return nil, errUnexpected
+	default:
+		return n, nil
+	}
 	for {
 		bs, tt, err := d.nextToken()
 		if err != nil {
-			return err
+			return nil, err
 		}
-		err = tokStack.push(tt)
+		if tt == endType {
+			n.end = bs
+			n.width += len(bs)
+			break
+		}
+		d.doUndo(bs, tt)
+		elem, err := d.ppNode()
 		if err != nil {
-			return err
+			return nil, err
 		}
-		prevType := curType
-		prevSize := curSize
-		if len(tokStack.toks) > 0 {
-			curType = tokStack.peek()
-			curSize = tokStack.peekCount()
+		if len(n.elems) > 0 {
+			n.width++
+			if n.tt == tokenMapStart && len(n.elems)%2 == 0 {
+				n.width++ // for the comma
+			}
 		}
-		// Indentation
-		switch tt {
-		case tokenVectorEnd, tokenListEnd, tokenMapEnd:
-		default:
-			switch prevType {
-			case tokenMapStart:
-				if prevSize%2 == 0 && prevSize > 0 {
-					dst.Write(commaOutputBytes)
-					pprintIndent(dst, shift[len(shift)-1])
-					col = shift[len(shift)-1]
-				} else if prevSize%2 == 1 { // We're a value, add a space after the key
-					dst.Write(spaceOutputBytes)
-					col++
+		n.elems = append(n.elems, elem)
+		n.width += elem.width
+	}
+	return n, nil
+}
+
+type pprinter struct {
+	dst    io.Writer
+	col    int
+	margin int
+	miser  int
+}
+
+func (p *pprinter) write(bs []byte, width int) {
+	p.dst.Write(bs)
+	p.col += width
+}
+
+func (p *pprinter) newline(indent int) {
+	pprintIndent(p.dst, indent)
+	p.col = indent
+}
+
+// fits reports whether n can be printed on a single line from the current
+// column, after writing extra additional characters.
+func (p *pprinter) fits(n *ppNode, extra int) bool {
+	return p.col+extra+n.width <= p.margin
+}
+
+// node prints n and returns whether it was printed over multiple lines.
+func (p *pprinter) node(n *ppNode) bool {
+	if n.elems == nil && n.end == nil { // atom
+		p.write(n.bs, n.width)
+		return false
+	}
+	if p.fits(n, 0) {
+		p.flat(n)
+		return false
+	}
+	if n.tt == tokenTag {
+		p.write(n.bs, utf8.RuneCount(n.bs))
+		p.write(spaceOutputBytes, 1)
+		return p.node(n.elems[0])
+	}
+	if n.tt == tokenMeta {
+		// put the value on the next line if it does not fit after the metadata
+		indent := p.col
+		p.write(n.bs, 1)
+		p.node(n.elems[0])
+		if p.fits(n.elems[1], 1) {
+			p.write(spaceOutputBytes, 1)
+		} else {
+			p.newline(indent)
+		}
+		p.node(n.elems[1])
+		return true
+	}
+	miser := p.miser >= 0 && p.col >= p.margin-p.miser
+	p.write(n.bs, utf8.RuneCount(n.bs))
+	indent := p.col
+	if n.tt == tokenMapStart {
+		for i := 0; i < len(n.elems); i += 2 {
+			if i > 0 {
+				p.write(commaOutputBytes, 1)
+				p.newline(indent)
+			}
+			p.node(n.elems[i])
+			if i+1 == len(n.elems) {
+				break
+			}
+			val := n.elems[i+1]
+			if miser && !p.fits(val, 1) {
+				p.newline(indent)
+			} else {
+				p.write(spaceOutputBytes, 1)
+			}
+			p.node(val)
+		}
+	} else {
+		broken := false
+		for i, elem := range n.elems {
+			if i > 0 {
+				extra := 1
+				if i == len(n.elems)-1 {
+					extra++ // make room for the closing delimiter
 				}
-			case tokenSetStart, tokenVectorStart, tokenListStart:
-				if prevColl {
-					// begin on new line where prevColl started
-					// This will look so strange for heterogenous maps.
-					pprintIndent(dst, prevCollStart)
-					col = prevCollStart
-				} else if prevSize > 0 {
-					dst.Write(spaceOutputBytes)
-					col++
+				if miser || broken || !p.fits(elem, extra) {
+					p.newline(indent)
+				} else {
+					p.write(spaceOutputBytes, 1)
 				}
 			}
+			broken = p.node(elem)
 		}
-		switch tt {
-		case tokenMapStart, tokenVectorStart, tokenListStart, tokenSetStart:
-			dst.Write(bs)
-			col += len(bs)             // either 2 or 1
-			shift = append(shift, col) // we only use maps for now, but we'll utilise this more thoroughly later on
-		case tokenVectorEnd, tokenListEnd, tokenMapEnd: // tokenSetEnd == tokenMapEnd
-			dst.Write(bs) // all of these are of length 1 in bytes, so this is ok
-			prevCollStart = shift[len(shift)-1] - 1
-			shift = shift[:len(shift)-1]
-		case tokenTag:
-			bslen := utf8.RuneCount(bs)
-			dst.Write(bs)
-			dst.Write(spaceOutputBytes)
-			col += bslen + 1
-		default:
-			bslen := utf8.RuneCount(bs)
-			dst.Write(bs)
-			col += bslen
-		}
-		prevColl = (tt == tokenMapEnd || tt == tokenVectorEnd || tt == tokenListEnd)
-		if tokStack.done() {
-			break
+	}
+	p.write(n.end, 1)
+	return true
+}
+
+// flat prints n on a single line.
+func (p *pprinter) flat(n *ppNode) {
+	if n.elems == nil && n.end == nil {
+		p.write(n.bs, n.width)
+		return
+	}
+	p.write(n.bs, utf8.RuneCount(n.bs))
+	if n.tt == tokenTag {
+		p.write(spaceOutputBytes, 1)
+		p.flat(n.elems[0])
+		return
+	}
+	if n.tt == tokenMeta {
+		p.flat(n.elems[0])
+		p.write(spaceOutputBytes, 1)
+		p.flat(n.elems[1])
+		return
+	}
+	for i, elem := range n.elems {
+		if i > 0 {
+			if n.tt == tokenMapStart && i%2 == 0 {
+				p.write(commaOutputBytes, 1)
+			}
+			p.write(spaceOutputBytes, 1)
 		}
+		p.flat(elem)
 	}
-	return nil
+	p.write(n.end, 1)
 }
@@ -14,7 +14,10 @@ func TestPPrint(t *testing.T) {
 		"{}":          "{}",
 		"[]":          "[]",
 		"{:a 42}":     "{:a 42}",
-		"{:a 1 :b 2}": "{:a 1,\n :b 2}",
+		"{:a 1 :b 2}": "{:a 1, :b 2}",
+		"#{1 2 3}":    "#{1 2 3}",
+		"#foo [1 2]":  "#foo [1 2]",
+		"(a #_b c)":   "(a c)",
 	}
 
 	for input, expected := range inputs {
@@ -29,3 +32,59 @@ func TestPPrint(t *testing.T) {
 		}
 	}
 }
+
+func TestPPrintMargin(t *testing.T) {
+	tests := []struct {
+		input    string
+		opts     PPrintOpts
+		expected string
+	}{
+		{
+			"{:a 1 :b 2}",
+			PPrintOpts{RightMargin: 10},
+			"{:a 1,\n :b 2}",
+		},
+		{
+			"[1 2 3 4 5 6 7 8 9 10 11 12]",
+			PPrintOpts{RightMargin: 12, MiserWidth: -1},
+			"[1 2 3 4 5 6\n 7 8 9 10 11\n 12]",
+		},
+		{
+			"{:numbers [1 2 3 4 5 6 7 8 9] :x 1}",
+			PPrintOpts{RightMargin: 24, MiserWidth: -1},
+			"{:numbers [1 2 3 4 5 6 7\n           8 9],\n :x 1}",
+		},
+		{
+			"[[1 2 3] [4 5 6] [7 8 9]]",
+			PPrintOpts{RightMargin: 20, MiserWidth: -1},
+			"[[1 2 3] [4 5 6]\n [7 8 9]]",
+		},
+		{
+			"{:numbers [1 2 3 4 5 6 7 8 9] :x 1}",
+			PPrintOpts{RightMargin: 24, MiserWidth: 20},
+			"{:numbers [1\n           2\n           3\n           4\n           5\n           6\n           7\n           8\n           9],\n :x 1}",
+		},
+		{
+			"{:a-long-key {:another-long-key 1}}",
+			PPrintOpts{RightMargin: 30, MiserWidth: 20},
+			"{:a-long-key {:another-long-key\n              1}}",
+		},
+		{
+			`#tag {:a "foo" :b "bar"}`,
+			PPrintOpts{RightMargin: 20},
+			"#tag {:a \"foo\",\n      :b \"bar\"}",
+		},
+	}
+
+	for _, test := range tests {
+		buff := bytes.NewBuffer(nil)
+		if err := PPrint(buff, []byte(test.input), &test.opts); err != nil {
+			t.Errorf(`PPrint(%q) failed, but expected success: %v`, test.input, err)
+		}
+
+		output := string(buff.Bytes())
+		if output != test.expected {
+			t.Errorf(`Expected PPrint(%q) to be %q; was %q`, test.input, test.expected, output)
+		}
+	}
+}
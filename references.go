@@ -0,0 +1,270 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+)
+
+// The tags used for shared references. A value tagged with #edn/id N is
+// referred to by #edn/ref N later in the same top level value.
+const (
+	refIDTag = "edn/id"
+	refTag   = "edn/ref"
+)
+
+// UseReferences makes the encoder write pointers which occur more than once in
+// a value only once. The first occurrence is tagged with #edn/id and a number
+// unique within the value, and later occurrences are written as #edn/ref with
+// that number. This makes it possible to encode cyclic data structures, as
+// long as every cycle passes through a pointer.
+//
+// Decoders using references resolve these tags back into shared pointers. See
+// Decoder.UseReferences.
+func (e *Encoder) UseReferences() {
+	e.ec.references = true
+}
+
+// UseReferences makes the decoder resolve references written by an Encoder
+// using references: A value tagged with #edn/id is decoded as usual, and every
+// #edn/ref with the same number is decoded into the same pointer, or, when
+// decoding into an empty interface, the same value. Cyclic references can only
+// be resolved into pointers.
+func (d *Decoder) UseReferences() {
+	d.references = true
+}
+
+// ptrKey identifies a pointer, map or slice while encoding.
+type ptrKey struct {
+	ptr uintptr
+	len int // length of a slice
+	t   reflect.Type
+}
+
+func ptrKeyOf(v reflect.Value) ptrKey {
+	k := ptrKey{ptr: v.Pointer(), t: v.Type()}
+	if v.Kind() == reflect.Slice {
+		k.len = v.Len()
+	}
+	return k
+}
+
+// refState keeps track of shared pointers when encoding with references. A
+// value is encoded twice: First to count how often each pointer occurs, and
+// then to write it.
+type refState struct {
+	counting bool
+	count    map[ptrKey]int
+	ids      map[ptrKey]int
+}
+
+// reference handles the non-nil pointer v when encoding with references. It
+// returns true if v is handled, either because it has been counted before or
+// because a reference to it has been written. Otherwise v must be encoded as
+// usual.
+func (e *encodeState) reference(v reflect.Value) bool {
+	if v.Type().Elem().Size() == 0 {
+		// pointers to zero-sized values may be equal without being shared
+		return false
+	}
+	rs := e.refs
+	k := ptrKeyOf(v)
+	if rs.counting {
+		rs.count[k]++
+		return rs.count[k] > 1
+	}
+	if rs.count[k] < 2 {
+		return false
+	}
+	e.ensureDelim()
+	if id, ok := rs.ids[k]; ok {
+		e.WriteString("#" + refTag + " " + strconv.Itoa(id))
+		e.needsDelim = true
+		return true
+	}
+	id := len(rs.ids) + 1
+	rs.ids[k] = id
+	e.WriteString("#" + refIDTag + " " + strconv.Itoa(id))
+	e.needsDelim = true
+	return false
+}
+
+// enter records that the encoder descends into the pointer, map or slice v,
+// and fails if v is already being encoded.
+func (e *encodeState) enter(v reflect.Value) {
+	k := ptrKeyOf(v)
+	if _, ok := e.visiting[k]; ok {
+		e.error(&UnsupportedValueError{v, "encountered a cycle via " + v.Type().String() +
+			errorContext(e.pathString(), Position{}, "")})
+	}
+	if e.visiting == nil {
+		e.visiting = map[ptrKey]struct{}{}
+	}
+	e.visiting[k] = struct{}{}
+}
+
+func (e *encodeState) leave(v reflect.Value) {
+	delete(e.visiting, ptrKeyOf(v))
+}
+
+// An encPathElem is a single step on the path from the top level value to the
+// value currently being encoded.
+type encPathElem struct {
+	index int           // index into a slice or array
+	key   reflect.Value // map key or set element, if valid
+	field *field        // struct field, if not nil
+}
+
+func (e *encodeState) pushIndex(i int) {
+	e.path = append(e.path, encPathElem{index: i})
+}
+
+func (e *encodeState) pushKey(k reflect.Value) {
+	e.path = append(e.path, encPathElem{key: k})
+}
+
+func (e *encodeState) pushField(f *field) {
+	e.path = append(e.path, encPathElem{field: f})
+}
+
+func (e *encodeState) popPath() {
+	e.path = e.path[:len(e.path)-1]
+}
+
+// pathString returns the current path as an EDN vector, in the same format as
+// the paths of decoding errors. Keys which are collections or pointers are
+// abbreviated.
+func (e *encodeState) pathString() string {
+	if len(e.path) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, elem := range e.path {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		switch {
+		case elem.field != nil:
+			sub := &encodeState{}
+			sub.fieldKey(elem.field)
+			buf.Write(sub.Bytes())
+		case elem.key.IsValid():
+			k := elem.key
+			for k.Kind() == reflect.Interface && !k.IsNil() {
+				k = k.Elem()
+			}
+			switch k.Kind() {
+			case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Ptr:
+				buf.Write(pathEllipsis)
+			default:
+				sub := &encodeState{}
+				sub.reflectValue(k)
+				buf.Write(sub.Bytes())
+			}
+		default:
+			buf.WriteString(strconv.Itoa(elem.index))
+		}
+	}
+	buf.WriteByte(']')
+	return buf.String()
+}
+
+// referenceTag reports whether tag, including the leading #, is one of the
+// reference tags.
+func referenceTag(tag []byte) bool {
+	s := string(tag[1:])
+	return s == refIDTag || s == refTag
+}
+
+// referenceID reads the number following a reference tag.
+func (d *Decoder) referenceID() int64 {
+	bs, tt, err := d.nextToken()
+	if err != nil {
+		d.error(err)
+	}
+	if tt != tokenInt {
+		d.error(errUnexpected)
+	}
+	id, err := strconv.ParseInt(string(bs), 10, 64)
+	if err != nil {
+		d.error(errUnexpected)
+	}
+	return id
+}
+
+// resolve returns the value with the given id, or fails if the id is not
+// known. Values decoded into an empty interface are not known until they are
+// completely decoded.
+func (d *Decoder) resolve(id int64) reflect.Value {
+	r := d.refs[id]
+	if !r.IsValid() {
+		d.error(&SyntaxError{msg: "unresolved reference #" + refTag + " " + strconv.FormatInt(id, 10)})
+	}
+	return r
+}
+
+// reference decodes the reference tag tag and its value into v.
+func (d *Decoder) reference(tag []byte, v reflect.Value) {
+	id := d.referenceID()
+	if d.refs == nil {
+		d.refs = make(map[int64]reflect.Value)
+	}
+	if string(tag[1:]) == refTag {
+		r := d.resolve(id)
+		switch {
+		case r.Type().AssignableTo(v.Type()):
+			v.Set(r)
+		case r.Kind() == reflect.Ptr && r.Type().Elem().AssignableTo(v.Type()):
+			v.Set(r.Elem())
+		default:
+			d.typeError(&UnmarshalTypeError{Value: "reference", Type: v.Type()})
+		}
+		return
+	}
+	switch {
+	case v.Kind() == reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		// register the pointer before decoding, so that cyclic references to
+		// it can be resolved
+		ptr := reflect.New(v.Type()).Elem()
+		ptr.Set(v)
+		d.refs[id] = ptr
+		d.value(v)
+	case v.Kind() == reflect.Interface && v.NumMethod() == 0:
+		if val := d.referenceInterface(tag, id); val != nil {
+			v.Set(reflect.ValueOf(val))
+		} else {
+			v.Set(reflect.Zero(v.Type()))
+		}
+	case v.CanAddr():
+		d.refs[id] = v.Addr()
+		d.value(v)
+	default:
+		d.value(v)
+	}
+}
+
+// referenceInterface decodes the reference tag tag with the given id into an
+// empty interface.
+func (d *Decoder) referenceInterface(tag []byte, id int64) interface{} {
+	if d.refs == nil {
+		d.refs = make(map[int64]reflect.Value)
+	}
+	if string(tag[1:]) == refTag {
+		return d.resolve(id).Interface()
+	}
+	val := d.valueInterface()
+	if val == nil {
+		d.refs[id] = reflect.Zero(emptyInterfaceType)
+	} else {
+		d.refs[id] = reflect.ValueOf(val)
+	}
+	return val
+}
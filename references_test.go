@@ -0,0 +1,119 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type refNode struct {
+	Name     string
+	Next     *refNode
+	Children []*refNode
+}
+
+func TestCycleError(t *testing.T) {
+	a := &refNode{Name: "a"}
+	b := &refNode{Name: "b", Next: a}
+	a.Children = []*refNode{b}
+	_, err := Marshal(a)
+	uerr, ok := err.(*UnsupportedValueError)
+	if !ok {
+		t.Fatalf("Expected UnsupportedValueError, got %v", err)
+	}
+	if !strings.Contains(uerr.Str, "cycle via *edn.refNode") ||
+		!strings.Contains(uerr.Str, "[:children 0 :next]") {
+		t.Errorf("Expected cycle type and path in error, got %q", uerr.Str)
+	}
+
+	m := map[string]interface{}{}
+	m["self"] = []interface{}{1, m}
+	_, err = Marshal(m)
+	if uerr, ok := err.(*UnsupportedValueError); !ok ||
+		!strings.Contains(uerr.Str, `["self" 1]`) {
+		t.Errorf("Expected cycle error for map, got %v", err)
+	}
+
+	// shared values are not cycles
+	shared := &refNode{Name: "shared"}
+	bs, err := Marshal([]*refNode{shared, shared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Count(bs, []byte(`"shared"`)) != 2 {
+		t.Errorf("Expected shared value to be written twice, got %s", bs)
+	}
+
+	// deep values without cycles are fine
+	var deep *refNode
+	for i := 0; i < 10000; i++ {
+		deep = &refNode{Next: deep}
+	}
+	if _, err := Marshal(deep); err != nil {
+		t.Errorf("Expected deep value to be encoded, got %v", err)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	shared := &refNode{Name: "shared"}
+	a := &refNode{Name: "a", Children: []*refNode{shared, shared}}
+	a.Next = a
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.UseReferences()
+	if err := enc.Encode(a); err != nil {
+		t.Fatal(err)
+	}
+	expected := `#edn/id 1{:name"a":next #edn/ref 1 :children[#edn/id 2{:name"shared":next nil :children nil}#edn/ref 2]}`
+	if strings.TrimSpace(buf.String()) != expected {
+		t.Errorf("Expected %s, got %s", expected, buf.String())
+	}
+
+	var res *refNode
+	dec := NewDecoder(bytes.NewReader(buf.Bytes()))
+	dec.UseReferences()
+	if err := dec.Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Next != res {
+		t.Errorf("Expected cyclic pointer to be restored")
+	}
+	if len(res.Children) != 2 || res.Children[0] != res.Children[1] ||
+		res.Children[0].Name != "shared" {
+		t.Errorf("Expected shared pointer to be restored, got %+v", res.Children)
+	}
+
+	// without references, the tags are unknown
+	if err := UnmarshalString(buf.String(), &res); err == nil {
+		t.Errorf("Expected error when decoding references without UseReferences")
+	}
+}
+
+func TestReferencesInterface(t *testing.T) {
+	var val interface{}
+	dec := NewDecoder(strings.NewReader(`[#edn/id 1 {:a 1} #edn/ref 1 #edn/ref 2]`))
+	dec.UseReferences()
+	err := dec.Decode(&val)
+	if err == nil || !strings.Contains(err.Error(), "unresolved reference #edn/ref 2") {
+		t.Errorf("Expected unresolved reference error, got %v", err)
+	}
+
+	dec = NewDecoder(strings.NewReader(`[#edn/id 1 {:a 1} #edn/ref 1] [#edn/ref 1]`))
+	dec.UseReferences()
+	if err := dec.Decode(&val); err != nil {
+		t.Fatal(err)
+	}
+	vec := val.([]interface{})
+	if len(vec) != 2 || !Equal(vec[0], vec[1]) {
+		t.Errorf("Expected reference to resolve to the same value, got %v", vec)
+	}
+	// references are local to a single top level value
+	if err := dec.Decode(&val); err == nil {
+		t.Errorf("Expected references not to carry over between values")
+	}
+}
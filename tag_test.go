@@ -389,3 +389,301 @@ func TestQuickBytes(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestIsolateTags(t *testing.T) {
+	MustAddTagFn("isolated/global", func(s string) (string, error) {
+		return "global " + s, nil
+	})
+	input := `[#isolated/global "a" #isolated/local "b" #inst "2015-01-01T00:00:00Z"]`
+
+	d := NewDecoder(bytes.NewBufferString(input))
+	if err := d.IsolateTags("inst"); err != nil {
+		t.Fatal(err)
+	}
+	d.MustAddTagFn("isolated/local", func(s string) (string, error) {
+		return "local " + s, nil
+	})
+	var val []interface{}
+	if err := d.Decode(&val); err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		Tag{"isolated/global", "a"},
+		"local b",
+		time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(val, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, val)
+	}
+
+	d = NewDecoder(bytes.NewBufferString(`#inst "2015-01-01T00:00:00Z"`))
+	d.IsolateTags()
+	var tag Tag
+	if err := d.Decode(&tag); err != nil || tag.Tagname != "inst" {
+		t.Errorf("Expected #inst to be unknown, got %v, %v", tag, err)
+	}
+
+	if err := NewDecoder(nil).IsolateTags("isolated/global"); err != ErrNotBuiltinTag {
+		t.Errorf("Expected ErrNotBuiltinTag, got %v", err)
+	}
+}
+
+func TestUnknownTagPolicy(t *testing.T) {
+	input := `[#unknown/tag 1 #unknown/tag [2]]`
+
+	d := NewDecoder(bytes.NewBufferString(input))
+	d.UseUnknownTagPolicy(RejectUnknownTags)
+	var iface interface{}
+	if _, ok := d.Decode(&iface).(UnknownTagError); !ok {
+		t.Error("Expected RejectUnknownTags to reject unknown tags in interfaces")
+	}
+	d = NewDecoder(bytes.NewBufferString(input))
+	d.UseUnknownTagPolicy(RejectUnknownTags)
+	d.CollectErrors()
+	var ifaces []interface{}
+	err := d.Decode(&ifaces)
+	if errs, ok := err.(ErrorList); !ok || len(errs) != 2 {
+		t.Errorf("Expected two errors, got %v", err)
+	} else {
+		for _, err := range errs {
+			if _, ok := err.(UnknownTagError); !ok {
+				t.Errorf("Expected UnknownTagError, got %T: %v", err, err)
+			}
+		}
+	}
+
+	d = NewDecoder(bytes.NewBufferString(input))
+	d.UseUnknownTagPolicy(KeepUnknownTags)
+	var tags []Tag
+	if err := d.Decode(&tags); err != nil {
+		t.Error(err)
+	}
+	d = NewDecoder(bytes.NewBufferString(input))
+	d.UseUnknownTagPolicy(KeepUnknownTags)
+	var ints []int
+	if d.Decode(&ints) == nil {
+		t.Error("Expected KeepUnknownTags to fail when decoding into ints")
+	}
+
+	d = NewDecoder(bytes.NewBufferString(input))
+	d.UseUnknownTagFn(func(tag Tag) (interface{}, error) {
+		if n, ok := tag.Value.(int64); ok {
+			return int(n), nil
+		}
+		return nil, fmt.Errorf("cannot convert %v", tag)
+	})
+	err = d.Decode(&ints)
+	if terr, ok := err.(*TagError); !ok || terr.Err.Error() != "cannot convert #unknown/tag [2]" {
+		t.Errorf("Expected an error from the unknown tag fn, got %v", err)
+	}
+	if !reflect.DeepEqual(ints, []int{1, 0}) {
+		t.Errorf("Expected [1 0], got %v", ints)
+	}
+
+	d = NewDecoder(bytes.NewBufferString(input))
+	d.UseUnknownTagFn(func(tag Tag) (interface{}, error) {
+		return nil, nil
+	})
+	ifaces = nil
+	if err := d.Decode(&ifaces); err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(ifaces, []interface{}{nil, nil}) {
+		t.Errorf("Expected [nil nil], got %v", ifaces)
+	}
+}
+
+type registeredUser struct {
+	Name string
+}
+
+func (u registeredUser) MarshalEDN() ([]byte, error) {
+	return []byte(`"not used"`), nil
+}
+
+func TestRegisterType(t *testing.T) {
+	var tm TagMap
+	err := tm.RegisterType("test/user", registeredUser{},
+		func(u registeredUser) (string, error) { return u.Name, nil },
+		func(s string) (*registeredUser, error) { return &registeredUser{s}, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	type Doc struct {
+		Owner  *registeredUser
+		Member interface{}
+		Plain  registeredUser
+	}
+	doc := Doc{&registeredUser{"a"}, registeredUser{"b"}, registeredUser{"c"}}
+
+	// without the TagMap, the type is encoded as usual
+	bs, err := Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{:owner "not used" :member "not used" :plain "not used"}`; string(bs) != expected {
+		t.Errorf("Expected %s, got %s", expected, bs)
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.UseTagMap(&tm)
+	if err := enc.Encode(doc); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{:owner #test/user"a":member #test/user"b":plain #test/user"c"}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, buf.String())
+	}
+
+	var res Doc
+	dec := NewDecoder(&buf)
+	dec.UseTagMap(&tm)
+	if err := dec.Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, Doc{&registeredUser{"a"}, &registeredUser{"b"}, registeredUser{"c"}}) {
+		t.Errorf("Expected the document to round-trip, got %#v", res)
+	}
+
+	if err := tm.RegisterType("test/user", registeredUser{}, func(s string) (string, error) {
+		return s, nil
+	}, nil); err != ErrMismatchTypes {
+		t.Errorf("Expected ErrMismatchTypes, got %v", err)
+	}
+	if err := tm.RegisterType("not a tag", registeredUser{}, nil, nil); err != ErrInvalidTag {
+		t.Errorf("Expected ErrInvalidTag, got %v", err)
+	}
+}
+
+func TestUUID(t *testing.T) {
+	const s = "5c2d088b-bc77-47ec-8721-7fb78555ebaf"
+	expected := UUID{0x5c, 0x2d, 0x08, 0x8b, 0xbc, 0x77, 0x47, 0xec,
+		0x87, 0x21, 0x7f, 0xb7, 0x85, 0x55, 0xeb, 0xaf}
+	var val interface{}
+	if err := UnmarshalString(`#uuid "5C2D088B-BC77-47EC-8721-7FB78555EBAF"`, &val); err != nil {
+		t.Fatal(err)
+	}
+	if val != expected {
+		t.Errorf("Expected UUID %s, got %#v", expected, val)
+	}
+	var user struct {
+		ID   UUID
+		Refs []UUID
+	}
+	if err := UnmarshalString(`{:iD #uuid "`+s+`" :refs [#uuid "`+s+`"]}`, &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != expected || len(user.Refs) != 1 || user.Refs[0] != expected {
+		t.Errorf("Expected UUID fields to be decoded, got %+v", user)
+	}
+	if user.ID.String() != s {
+		t.Errorf("Expected %s, got %s", s, user.ID)
+	}
+	bs, err := Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != `{:iD #uuid"`+s+`" :refs[#uuid"`+s+`"]}` {
+		t.Errorf("Expected UUIDs to encode as #uuid, got %s", bs)
+	}
+
+	for _, invalid := range []string{"", "5c2d088b-bc77-47ec-8721-7fb78555eba", "5c2d088bbc7747ec87217fb78555ebaf0000",
+		"5c2d088b-bc77-47ec-8721_7fb78555ebaf", "5c2d088b-bc77-47ec-8721-7fb78555ebag"} {
+		if _, err := ParseUUID(invalid); err == nil {
+			t.Errorf("Expected error when parsing %q", invalid)
+		}
+	}
+	if err := UnmarshalString(`#uuid "not-a-uuid"`, &user.ID); err == nil {
+		t.Errorf("Expected error when decoding invalid UUID")
+	}
+}
+
+func TestOverrideBuiltinTag(t *testing.T) {
+	defer func() {
+		globalTags.Lock()
+		delete(globalTags.m, "uuid")
+		globalTags.Unlock()
+	}()
+	if err := AddTagFn("uuid", func(s string) (string, error) { return "uuid " + s, nil }); err != nil {
+		t.Fatalf("Expected the built-in uuid tag to be replaceable, got %v", err)
+	}
+	var val interface{}
+	if err := UnmarshalString(`#uuid "x"`, &val); err != nil || val != "uuid x" {
+		t.Errorf("Expected the global uuid tag to be used, got %v (err: %v)", val, err)
+	}
+	if err := AddTagFn("uuid", ParseUUID); err != ErrTagOverwritten {
+		t.Errorf("Expected ErrTagOverwritten when replacing a user tag, got %v", err)
+	}
+}
+
+func TestReadInstForms(t *testing.T) {
+	minus5 := time.FixedZone("", -5*60*60)
+	tests := []struct {
+		in       string
+		expected time.Time
+	}{
+		{"1985", time.Date(1985, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"1985-04", time.Date(1985, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"1985-04-12", time.Date(1985, 4, 12, 0, 0, 0, 0, time.UTC)},
+		{"1985-04-12T23", time.Date(1985, 4, 12, 23, 0, 0, 0, time.UTC)},
+		{"1985-04-12T23:20", time.Date(1985, 4, 12, 23, 20, 0, 0, time.UTC)},
+		{"1985-04-12T23:20:50", time.Date(1985, 4, 12, 23, 20, 50, 0, time.UTC)},
+		{"1985-04-12T23:20:50.52Z", time.Date(1985, 4, 12, 23, 20, 50, 520000000, time.UTC)},
+		{"1985-04-12T23:20:50.1234567891-05:00", time.Date(1985, 4, 12, 23, 20, 50, 123456789, minus5)},
+		{"1985-04-12-05:00", time.Date(1985, 4, 12, 0, 0, 0, 0, minus5)},
+		{"1985-05:00", time.Date(1985, 1, 1, 0, 0, 0, 0, minus5)},
+		{"2015-08-29T21:28:34.311-00:00", time.Date(2015, 8, 29, 21, 28, 34, 311000000, time.UTC)},
+		{"2016-12-31T23:59:60Z", time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		var inst time.Time
+		if err := UnmarshalString(`#inst "`+test.in+`"`, &inst); err != nil {
+			t.Errorf("Unexpected error reading %s: %v", test.in, err)
+			continue
+		}
+		if !inst.Equal(test.expected) {
+			t.Errorf("Expected %s to be %s, got %s", test.in, test.expected, inst)
+		}
+	}
+	for _, invalid := range []string{"", "85", "1985-4", "1985-13", "1985-02-29", "1985-04-12T24",
+		"1985-04-12 23:20", "1985-04-12T23:20:50.", "1985-04-12T23:20:60", "1985-04-12T23:20:50+05",
+		"1985-04-12T23:20:50Z+05:00", "1985-04-12T23.5"} {
+		var inst time.Time
+		if err := UnmarshalString(`#inst "`+invalid+`"`, &inst); err == nil {
+			t.Errorf("Expected error reading %q, got %s", invalid, inst)
+		}
+	}
+}
+
+func TestEncodeInstOptions(t *testing.T) {
+	inst := time.Date(2015, 8, 29, 16, 28, 34, 311592000, time.FixedZone("", -5*60*60))
+	tests := []struct {
+		utc, millis bool
+		expected    string
+	}{
+		{false, false, `#inst"2015-08-29T16:28:34.311592-05:00"`},
+		{true, false, `#inst"2015-08-29T21:28:34.311592-00:00"`},
+		{false, true, `#inst"2015-08-29T16:28:34.311-05:00"`},
+		{true, true, `#inst"2015-08-29T21:28:34.311-00:00"`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		if test.utc {
+			enc.UseUTCInsts()
+		}
+		if test.millis {
+			enc.UseMillisecondInsts()
+		}
+		if err := enc.Encode([]time.Time{inst}); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "[" + test.expected + "]\n"; buf.String() != expected {
+			t.Errorf("Expected %s, got %s", expected, buf.String())
+		}
+	}
+	if bs, _ := Marshal(time.Date(2015, 8, 29, 0, 0, 0, 0, time.UTC)); string(bs) != `#inst"2015-08-29T00:00:00Z"` {
+		t.Errorf("Expected default encoding to be unchanged, got %s", bs)
+	}
+}
@@ -42,3 +42,36 @@ func (o tagOptions) Contains(optionName string) bool {
 	}
 	return false
 }
+
+// Get returns the value of the option optionName=value in the comma-separated
+// list of options, and whether the option is present.
+func (o tagOptions) Get(optionName string) (string, bool) {
+	s := string(o)
+	for s != "" {
+		var next string
+		i := strings.Index(s, ",")
+		if i >= 0 {
+			s, next = s[:i], s[i+1:]
+		}
+		if strings.HasPrefix(s, optionName+"=") {
+			return s[len(optionName)+1:], true
+		}
+		s = next
+	}
+	return "", false
+}
+
+// cut removes the option optionName=value from o and returns the remaining
+// options and the value. The value extends to the end of o, so it may contain
+// commas, but the option must be the last one.
+func (o tagOptions) cut(optionName string) (tagOptions, string, bool) {
+	s := string(o)
+	prefix := optionName + "="
+	if strings.HasPrefix(s, prefix) {
+		return "", s[len(prefix):], true
+	}
+	if i := strings.Index(s, ","+prefix); i >= 0 {
+		return tagOptions(s[:i]), s[i+1+len(prefix):], true
+	}
+	return o, "", false
+}
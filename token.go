@@ -0,0 +1,266 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"io"
+	"runtime"
+)
+
+// A TokenType is the type of a Token returned by Decoder.Token.
+type TokenType int
+
+const (
+	NilToken TokenType = iota
+	BoolToken
+	SymbolToken
+	KeywordToken
+	StringToken
+	IntToken
+	FloatToken
+	CharToken
+	TagToken
+	ListStartToken
+	ListEndToken
+	VectorStartToken
+	VectorEndToken
+	MapStartToken
+	MapEndToken
+	SetStartToken
+	SetEndToken
+	RatioToken
+)
+
+func (t TokenType) String() string {
+	switch t {
+	case NilToken:
+		return "nil"
+	case BoolToken:
+		return "boolean"
+	case SymbolToken:
+		return "symbol"
+	case KeywordToken:
+		return "keyword"
+	case StringToken:
+		return "string"
+	case IntToken:
+		return "integer"
+	case FloatToken:
+		return "float"
+	case CharToken:
+		return "character"
+	case TagToken:
+		return "tag"
+	case ListStartToken:
+		return "list start"
+	case ListEndToken:
+		return "list end"
+	case VectorStartToken:
+		return "vector start"
+	case VectorEndToken:
+		return "vector end"
+	case MapStartToken:
+		return "map start"
+	case MapEndToken:
+		return "map end"
+	case SetStartToken:
+		return "set start"
+	case SetEndToken:
+		return "set end"
+	case RatioToken:
+		return "ratio"
+	default:
+		return "[unknown]"
+	}
+}
+
+// A Token is a single lexical EDN element as returned by Decoder.Token.
+//
+// Raw contains the token as it was written in the input. For literals, Value
+// contains the same value the Decoder would store in an empty interface:
+// nil, bool, Symbol, Keyword, string, int64 or big.Int, float64 or *Decimal,
+// *big.Rat, or rune (int32), and Number for all numbers if the Decoder uses
+// numbers. See Unmarshal for details. For tags, Value is the tag name without
+// the leading '#' as a string. For collection delimiters, Value is nil.
+type Token struct {
+	Type  TokenType
+	Raw   []byte
+	Value interface{}
+}
+
+func (t Token) String() string {
+	return string(t.Raw)
+}
+
+// Token returns the next EDN token in the input stream. At the end of the input
+// stream, Token returns an empty Token and io.EOF.
+//
+// Token guarantees that the delimiters it returns are properly nested and
+// matched: if Token encounters an unexpected delimiter in the input, it will
+// return an error. Discarded values (#_) are skipped and never returned. A tag
+// token is always followed by the value it tags. Metadata is handled according
+// to the metadata policy of the decoder, but is never returned as tokens.
+//
+// Token can be mixed with calls to Decode and Skip. This makes it possible to
+// e.g. read the start of a huge vector with Token, then decode each element
+// with Decode until More returns false.
+func (d *Decoder) Token() (tok Token, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			err = r.(error)
+		}
+	}()
+
+	if d.savedError != nil {
+		err = d.savedError
+		d.savedError = nil
+		return Token{}, err
+	}
+	if d.tokens == nil {
+		d.tokens = newTokenStack()
+	}
+	d.bytesStart = d.pos.Offset
+	bs, tt, err := d.nextToken()
+	if err != nil {
+		return Token{}, d.tokenError(err)
+	}
+	tok.Raw = bs
+	switch tt {
+	case tokenSymbol, tokenKeyword, tokenString, tokenInt, tokenFloat, tokenRatio, tokenChar:
+		tok.Value = d.literalInterface(bs, tt)
+		switch tok.Value.(type) {
+		case nil:
+			tok.Type = NilToken
+		case bool:
+			tok.Type = BoolToken
+		default:
+			tok.Type = literalTokenTypes[tt]
+		}
+	case tokenTag:
+		tok.Type = TagToken
+		tok.Value = string(bs[1:])
+	case tokenListStart:
+		tok.Type = ListStartToken
+	case tokenListEnd:
+		tok.Type = ListEndToken
+	case tokenVectorStart:
+		tok.Type = VectorStartToken
+	case tokenVectorEnd:
+		tok.Type = VectorEndToken
+	case tokenMapStart:
+		tok.Type = MapStartToken
+	case tokenMapEnd:
+		tok.Type = MapEndToken
+		if len(d.tokens.toks) > 0 && d.tokens.peek() == tokenSetStart {
+			tok.Type = SetEndToken
+		}
+	case tokenSetStart:
+		tok.Type = SetStartToken
+	default:
+		return Token{}, errInternal
+	}
+	err = d.tokens.push(tt)
+	if err == nil {
+		err = d.checkStackDepth(0, d.tokens, d.tokenPos)
+	}
+	if err != nil {
+		return Token{}, d.tokenError(err)
+	}
+	return tok, nil
+}
+
+var literalTokenTypes = map[tokenType]TokenType{
+	tokenSymbol:  SymbolToken,
+	tokenKeyword: KeywordToken,
+	tokenString:  StringToken,
+	tokenInt:     IntToken,
+	tokenFloat:   FloatToken,
+	tokenRatio:   RatioToken,
+	tokenChar:    CharToken,
+}
+
+// More reports whether there is another element in the current list, vector,
+// map or set being read by Token. At the top level, More reports whether there
+// are more values in the input stream.
+func (d *Decoder) More() bool {
+	if d.savedError != nil {
+		return false
+	}
+	if !d.undo {
+		d.bytesStart = d.pos.Offset
+		bs, tt, err := d.nextToken()
+		if err != nil {
+			d.savedError = d.tokenError(err)
+			return false
+		}
+		d.doUndo(bs, tt)
+	}
+	switch d.prevTtype {
+	case tokenListEnd, tokenVectorEnd, tokenMapEnd:
+		return false
+	}
+	return true
+}
+
+// Skip reads the next EDN value from the input and throws it away. Nested
+// collections, tags and discards are skipped in their entirety. If the next
+// token closes the current collection, Skip returns an error and leaves the
+// token in place.
+func (d *Decoder) Skip() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			err = r.(error)
+		}
+	}()
+
+	if d.savedError != nil {
+		err = d.savedError
+		d.savedError = nil
+		return err
+	}
+	d.bytesStart = d.pos.Offset
+	bs, tt, err := d.nextToken()
+	if err != nil {
+		return d.tokenError(err)
+	}
+	d.doUndo(bs, tt)
+	switch tt {
+	case tokenListEnd, tokenVectorEnd, tokenMapEnd:
+		return d.positioned(errUnexpected)
+	}
+	err = d.traverseValue()
+	if err != nil {
+		return d.tokenError(err)
+	}
+	d.consumedValue()
+	return nil
+}
+
+// consumedValue notifies the token stack used by Token that a full value has
+// been read by some other means than Token, e.g. by Decode or Skip.
+func (d *Decoder) consumedValue() {
+	if d.tokens != nil && len(d.tokens.toks) > 0 {
+		d.tokens.push(tokenSymbol)
+	}
+}
+
+// tokenError converts errors from nextToken into the errors returned by the
+// token API. Hitting the end of the input between two top level values is
+// io.EOF, whereas hitting it anywhere else is io.ErrUnexpectedEOF.
+func (d *Decoder) tokenError(err error) error {
+	if err != errNoneLeft {
+		return d.positioned(err)
+	}
+	if d.tokens != nil && len(d.tokens.toks) > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return io.EOF
+}
@@ -0,0 +1,178 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	input := `{:a [1 2.5 "s"] #_ ignored b #{\c nil}} #foo (true)`
+	expected := []Token{
+		{MapStartToken, []byte("{"), nil},
+		{KeywordToken, []byte(":a"), Keyword("a")},
+		{VectorStartToken, []byte("["), nil},
+		{IntToken, []byte("1"), int64(1)},
+		{FloatToken, []byte("2.5"), float64(2.5)},
+		{StringToken, []byte(`"s"`), "s"},
+		{VectorEndToken, []byte("]"), nil},
+		{SymbolToken, []byte("b"), Symbol("b")},
+		{SetStartToken, []byte("#{"), nil},
+		{CharToken, []byte(`\c`), 'c'},
+		{NilToken, []byte("nil"), nil},
+		{SetEndToken, []byte("}"), nil},
+		{MapEndToken, []byte("}"), nil},
+		{TagToken, []byte("#foo"), "foo"},
+		{ListStartToken, []byte("("), nil},
+		{BoolToken, []byte("true"), true},
+		{ListEndToken, []byte(")"), nil},
+	}
+	d := NewDecoder(strings.NewReader(input))
+	for i, want := range expected {
+		tok, err := d.Token()
+		if err != nil {
+			t.Fatalf("Token %d: unexpected error: %s", i, err)
+		}
+		if !reflect.DeepEqual(tok, want) {
+			t.Errorf("Token %d: expected %#v, got %#v", i, want, tok)
+		}
+	}
+	if _, err := d.Token(); err != io.EOF {
+		t.Errorf("Expected io.EOF at end of input, got %v", err)
+	}
+}
+
+func TestTokenNumberTypes(t *testing.T) {
+	const input = `1N 1.5M 1/2`
+	d := NewDecoder(strings.NewReader(input))
+	for i, want := range []interface{}{big.Int{}, float64(0), (*big.Rat)(nil)} {
+		tok, err := d.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.TypeOf(tok.Value) != reflect.TypeOf(want) {
+			t.Errorf("Token %d: expected a %T, got %T", i, want, tok.Value)
+		}
+	}
+
+	d = NewDecoder(strings.NewReader(input))
+	d.UseDecimals()
+	d.Token()
+	if tok, err := d.Token(); err != nil || reflect.TypeOf(tok.Value) != reflect.TypeOf((*Decimal)(nil)) {
+		t.Errorf("Expected a *Decimal when using decimals, got %T (err: %v)", tok.Value, err)
+	}
+}
+
+func TestTokenMismatchedDelimiter(t *testing.T) {
+	d := NewDecoder(strings.NewReader(`[1 2)`))
+	var err error
+	for err == nil {
+		_, err = d.Token()
+	}
+	if serr, ok := err.(*SyntaxError); !ok || serr.Line != 1 || serr.Column != 5 {
+		t.Errorf("Expected a syntax error at line 1, column 5, got %v", err)
+	}
+}
+
+func TestTokenLimits(t *testing.T) {
+	d := NewDecoder(strings.NewReader("[1 2 3 4 5 6 7 8 9      10]"))
+	d.UseLimits(DecoderLimits{MaxBytes: 4})
+	var err error
+	var n int
+	for ; err == nil; n++ {
+		_, err = d.Token()
+	}
+	if le, ok := err.(*LimitError); !ok || le.Limit != "MaxBytes" || n != 11 {
+		t.Errorf("Expected MaxBytes to be exceeded by token 11, got %v at token %d", err, n)
+	}
+}
+
+func TestTokenUnexpectedEOF(t *testing.T) {
+	d := NewDecoder(strings.NewReader(`[1 2`))
+	var err error
+	for err == nil {
+		_, err = d.Token()
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestTokenMoreDecode(t *testing.T) {
+	type Elem struct {
+		ID int
+	}
+	input := `[{:id 1} #_{:id 100} {:id 2} #foo/bar {:id 50} {:id 3}] :after`
+	d := NewDecoder(strings.NewReader(input))
+	tok, err := d.Token()
+	if err != nil || tok.Type != VectorStartToken {
+		t.Fatalf("Expected vector start, got %v (err: %v)", tok, err)
+	}
+	var ids []int
+	for d.More() {
+		tok, err := d.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.Type == TagToken {
+			if err := d.Skip(); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if tok.Type != MapStartToken {
+			t.Fatalf("Expected map start, got %v", tok.Type)
+		}
+		var e Elem
+		for d.More() {
+			var key Keyword
+			if err := d.Decode(&key); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Decode(&e.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tok, err := d.Token(); err != nil || tok.Type != MapEndToken {
+			t.Fatalf("Expected map end, got %v (err: %v)", tok, err)
+		}
+		ids = append(ids, e.ID)
+	}
+	if tok, err := d.Token(); err != nil || tok.Type != VectorEndToken {
+		t.Fatalf("Expected vector end, got %v (err: %v)", tok, err)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 2, 3}) {
+		t.Errorf("Expected ids [1 2 3], got %v", ids)
+	}
+	var after Keyword
+	if err := d.Decode(&after); err != nil || after != Keyword("after") {
+		t.Errorf("Expected :after, got %v (err: %v)", after, err)
+	}
+	if d.More() {
+		t.Error("Expected More to return false at end of input")
+	}
+	if _, err := d.Token(); err != io.EOF {
+		t.Errorf("Expected io.EOF at end of input, got %v", err)
+	}
+}
+
+func TestSkipEndDelimiter(t *testing.T) {
+	d := NewDecoder(strings.NewReader(`[]`))
+	if _, err := d.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Skip(); err == nil {
+		t.Error("Expected Skip on collection end to fail")
+	} else if serr, ok := err.(*SyntaxError); !ok || serr.Column != 2 {
+		t.Errorf("Expected a syntax error at column 2, got %v", err)
+	}
+	if tok, err := d.Token(); err != nil || tok.Type != VectorEndToken {
+		t.Errorf("Expected vector end after failed Skip, got %v (err: %v)", tok, err)
+	}
+}
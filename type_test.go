@@ -5,6 +5,7 @@
 package edn
 
 import (
+	"strings"
 	"testing"
 	"testing/quick"
 )
@@ -138,3 +139,90 @@ func TestUnmarshalRawMessageValue(t *testing.T) {
 		t.Errorf("Expected result to be `[\\space #foo bar :baz 100{#{} 1.0 \"zap\" nil}]`, but was `%s`", string(f.Leftovers))
 	}
 }
+
+func TestCollectionTypes(t *testing.T) {
+	input := `[(a b) #{(1)} [] {:x (c)}]`
+	d := NewDecoder(strings.NewReader(input))
+	d.UseCollectionTypes()
+	var val interface{}
+	if err := d.Decode(&val); err != nil {
+		t.Fatal(err)
+	}
+	vec, ok := val.(Vector)
+	if !ok || len(vec) != 4 {
+		t.Fatalf("Expected a Vector with 4 elements, got %#v", val)
+	}
+	if _, ok := vec[0].(List); !ok {
+		t.Errorf("Expected a List, got %#v", vec[0])
+	}
+	if _, ok := vec[1].(Set); !ok {
+		t.Errorf("Expected a Set, got %#v", vec[1])
+	}
+	bs, err := Marshal(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != `[(a b)#{(1)}[]{:x(c)}]` {
+		t.Errorf("Expected the value to round-trip, got %s", bs)
+	}
+
+	bs, err = Marshal(struct {
+		L List `edn:"l,vector"`
+		S Set  `edn:"s,map"`
+	}{List{1}, Set{2: true, 3: false}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != `{:l(1):s #{2}}` {
+		t.Errorf("Expected List and Set to retain their brackets, got %s", bs)
+	}
+}
+
+func TestNamespacedNames(t *testing.T) {
+	var vals []interface{}
+	if err := UnmarshalString(`[:user/name clojure.core/map :plain / :a/b/c]`, &vals); err == nil {
+		t.Error("Expected :a/b/c to be invalid")
+	}
+	if err := UnmarshalString(`[:user/name clojure.core/map :plain /]`, &vals); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		val interface {
+			Namespace() string
+			Name() string
+		}
+		ns, name string
+	}{
+		{vals[0].(Keyword), "user", "name"},
+		{vals[1].(Symbol), "clojure.core", "map"},
+		{vals[2].(Keyword), "", "plain"},
+		{vals[3].(Symbol), "", "/"},
+	}
+	for _, test := range tests {
+		if test.val.Namespace() != test.ns || test.val.Name() != test.name {
+			t.Errorf("Expected %v to have namespace %q and name %q, got %q and %q",
+				test.val, test.ns, test.name, test.val.Namespace(), test.val.Name())
+		}
+	}
+	if NewKeyword("user", "name") != vals[0] || NewSymbol("", "map") != Symbol("map") {
+		t.Error("Expected NewKeyword and NewSymbol to construct namespaced names")
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	valid := []interface{}{Keyword("a"), Keyword("user/name"), Keyword("nil"), Keyword("-1a"),
+		Symbol("a"), Symbol("/"), Symbol("-"), Symbol("clojure.core/+"), Symbol("a#"), Symbol("ærø/skål")}
+	invalid := []interface{}{Keyword(""), Keyword("a b"), Keyword("/a"), Keyword(":a"),
+		Keyword("a/"), Symbol(""), Symbol("nil"), Symbol("1a"), Symbol("-1"), Symbol("a/b/c"),
+		Symbol("#a"), Symbol("a\"b"), Keyword("a/b/c")}
+	for _, v := range valid {
+		if _, err := Marshal(v); err != nil {
+			t.Errorf("Expected %#v to be valid, got %v", v, err)
+		}
+	}
+	for _, v := range invalid {
+		if bs, err := Marshal(v); err == nil {
+			t.Errorf("Expected %#v to be invalid, got %s", v, bs)
+		}
+	}
+}
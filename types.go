@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 // RawMessage is a raw encoded, but valid, EDN value. It implements Marshaler
@@ -33,28 +34,150 @@ func (m *RawMessage) UnmarshalEDN(data []byte) error {
 	return nil
 }
 
-// A Keyword is an EDN keyword without : prepended in front.
+// A Keyword is an EDN keyword without : prepended in front. A keyword may be
+// namespaced, e.g. Keyword("user/name") for the keyword :user/name.
 type Keyword string
 
+// NewKeyword returns the keyword with the namespace ns and the name name. If
+// ns is empty, the keyword is not namespaced.
+func NewKeyword(ns, name string) Keyword {
+	if ns == "" {
+		return Keyword(name)
+	}
+	return Keyword(ns + "/" + name)
+}
+
+// Namespace returns the namespace of k, or the empty string if k is not
+// namespaced.
+func (k Keyword) Namespace() string {
+	ns, _ := splitName(string(k))
+	return ns
+}
+
+// Name returns the name of k without its namespace.
+func (k Keyword) Name() string {
+	_, name := splitName(string(k))
+	return name
+}
+
 func (k Keyword) String() string {
 	return fmt.Sprintf(":%s", string(k))
 }
 
+// MarshalEDN returns ErrInvalidKeyword if k is not a valid EDN keyword.
 func (k Keyword) MarshalEDN() ([]byte, error) {
-	return []byte(k.String()), nil
+	s := k.String()
+	if !isValidName(s, tokenKeyword) {
+		return nil, ErrInvalidKeyword
+	}
+	return []byte(s), nil
 }
 
-// A Symbol is an EDN symbol.
+// A Symbol is an EDN symbol. A symbol may be namespaced, e.g.
+// Symbol("clojure.core/map").
 type Symbol string
 
+// NewSymbol returns the symbol with the namespace ns and the name name. If ns
+// is empty, the symbol is not namespaced.
+func NewSymbol(ns, name string) Symbol {
+	if ns == "" {
+		return Symbol(name)
+	}
+	return Symbol(ns + "/" + name)
+}
+
+// Namespace returns the namespace of s, or the empty string if s is not
+// namespaced.
+func (s Symbol) Namespace() string {
+	ns, _ := splitName(string(s))
+	return ns
+}
+
+// Name returns the name of s without its namespace.
+func (s Symbol) Name() string {
+	_, name := splitName(string(s))
+	return name
+}
+
 func (s Symbol) String() string {
 	return string(s)
 }
 
+// MarshalEDN returns ErrInvalidSymbol if s is not a valid EDN symbol. The
+// symbols nil, true and false are invalid, as they are reserved for other
+// values.
 func (s Symbol) MarshalEDN() ([]byte, error) {
+	if !isValidName(string(s), tokenSymbol) {
+		return nil, ErrInvalidSymbol
+	}
 	return []byte(s), nil
 }
 
+// splitName splits a symbol or keyword name into its namespace and name.
+func splitName(s string) (ns, name string) {
+	if i := strings.IndexByte(s, '/'); i > 0 && i < len(s)-1 {
+		return s[:i], s[i+1:]
+	}
+	return "", s
+}
+
+// isValidName reports whether s is a single token of type tt, and not one of
+// the symbols nil, true and false.
+func isValidName(s string, tt tokenType) bool {
+	switch s {
+	case "nil", "true", "false":
+		return false
+	}
+	name := s
+	if tt == tokenKeyword && len(s) > 0 && s[0] == ':' {
+		name = s[1:]
+	}
+	if isSimpleName(name) {
+		return true
+	}
+	stt, ok := singleToken(s)
+	return ok && stt == tt
+}
+
+// isSimpleName reports whether s is made up of ASCII letters, digits and the
+// characters allowed in symbols, where the name and the namespace, if any,
+// start with a letter. Such names are always valid, and checking this is a lot
+// cheaper than running the lexer.
+func isSimpleName(s string) bool {
+	start := true
+	ns := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case start:
+			return false
+		case '0' <= c && c <= '9', okSymbol(rune(c)):
+		case c == '/' && !ns:
+			ns = true
+			start = true
+			continue
+		default:
+			return false
+		}
+		start = false
+	}
+	return !start
+}
+
+// singleToken returns the type of the token s, and false if s is not a single
+// token.
+func singleToken(s string) (tokenType, bool) {
+	var lex lexer
+	lex.reset()
+	for _, r := range s {
+		if lex.state(r) != lexCont {
+			return tokenError, false
+		}
+	}
+	return lex.token, lex.eof() == lexEnd
+}
+
 // A Tag is a tagged value. The Tagname represents the name of the tag, and the
 // Value is the value of the element.
 type Tag struct {
@@ -108,6 +231,21 @@ tag:
 	return Unmarshal(bs[endTag:], &t.Value)
 }
 
+// A List is an EDN list. Lists are always encoded with parentheses. A Decoder
+// decodes lists into Lists when decoding into an empty interface, if it uses
+// collection types. See Decoder.UseCollectionTypes.
+type List []interface{}
+
+// A Vector is an EDN vector. A Decoder decodes vectors into Vectors when
+// decoding into an empty interface, if it uses collection types. See
+// Decoder.UseCollectionTypes.
+type Vector []interface{}
+
+// A Set is an EDN set. Sets are always encoded as EDN sets, containing the keys
+// with true values. A Decoder decodes sets into Sets when decoding into an
+// empty interface, if it uses collection types. See Decoder.UseCollectionTypes.
+type Set map[interface{}]bool
+
 // A Rune type is a wrapper for a rune. It can be used to encode runes as
 // characters instead of int32 values.
 type Rune rune
@@ -0,0 +1,73 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"fmt"
+)
+
+// A UUID is a universally unique identifier, the value of the built-in tag
+// #uuid. UUIDs encode as #uuid followed by the canonical string form of the
+// UUID, and a #uuid tagged value decodes into a UUID, also when decoding into
+// an empty interface.
+type UUID [16]byte
+
+// ParseUUID parses s as a UUID in the canonical string form
+// xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx, where the x are hexadecimal digits in
+// upper or lower case.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	j := 0
+	for i := 0; i < len(s); i += 2 {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return u, fmt.Errorf("invalid UUID %q", s)
+			}
+			i++
+		}
+		hi, ok1 := fromHexChar(s[i])
+		lo, ok2 := fromHexChar(s[i+1])
+		if !ok1 || !ok2 {
+			return u, fmt.Errorf("invalid UUID %q", s)
+		}
+		u[j] = hi<<4 | lo
+		j++
+	}
+	return u, nil
+}
+
+func fromHexChar(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// String returns the canonical string form of u, using lower case hexadecimal
+// digits.
+func (u UUID) String() string {
+	buf := make([]byte, 0, 36)
+	for i, b := range u {
+		switch i {
+		case 4, 6, 8, 10:
+			buf = append(buf, '-')
+		}
+		buf = append(buf, hex[b>>4], hex[b&0xF])
+	}
+	return string(buf)
+}
+
+func (u UUID) MarshalEDN() ([]byte, error) {
+	return []byte(`#uuid"` + u.String() + `"`), nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+)
+
+// A Value is an immutable EDN value. Values are comparable with ==, and two
+// Values are equal if their contents encode to the same EDN, regardless of the
+// order of map entries and set elements. This makes Values usable as map keys
+// and set elements where the value itself cannot be, e.g. for vectors, maps,
+// sets and big integers. Metadata is not part of a Value, so values which only
+// differ in their metadata are equal, as in Clojure. Likewise, big integers
+// which fit in an int64 are stored without the N suffix, so that they are
+// equal to the same integer without it.
+//
+// When decoding into an empty interface, a Decoder stores map keys and set
+// elements which are not hashable as Values. To look up such a key, create a
+// Value from an equal Go value:
+//
+//	var m map[interface{}]interface{}
+//	edn.UnmarshalString(`{[1 2] :a}`, &m)
+//	key, _ := edn.NewValue([]int{1, 2})
+//	m[key] // => edn.Keyword("a")
+//
+// Hashable keys and elements are stored as they are, not as Values. Decoding
+// {1 :a 1N :b} therefore gives a map with the keys int64(1) and the Value of
+// 1N, which are distinct keys even though Equal reports them as equal.
+//
+// The zero Value is the EDN value nil.
+type Value struct {
+	enc string // canonical encoding, empty for nil
+}
+
+// NewValue returns the Value of v. It returns an error if v cannot be encoded
+// as EDN.
+func NewValue(v interface{}) (Value, error) {
+	e := &encodeState{sortKeys: true, symbolic: true, noMeta: true, smallBigInts: true}
+	if err := e.marshal(v); err != nil {
+		return Value{}, err
+	}
+	// Marshalers may format their output differently, so compact it.
+	var buf bytes.Buffer
+	if err := Compact(&buf, e.Bytes()); err != nil {
+		return Value{}, err
+	}
+	if buf.String() == "nil" {
+		return Value{}, nil
+	}
+	return Value{enc: buf.String()}, nil
+}
+
+// String returns the EDN encoding of v.
+func (v Value) String() string {
+	if v.enc == "" {
+		return "nil"
+	}
+	return v.enc
+}
+
+func (v Value) MarshalEDN() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+func (v *Value) UnmarshalEDN(bs []byte) error {
+	var val interface{}
+	if err := Unmarshal(bs, &val); err != nil {
+		return err
+	}
+	nv, err := NewValue(val)
+	if err != nil {
+		return err
+	}
+	*v = nv
+	return nil
+}
+
+// Unmarshal decodes v into the value pointed to by into, like the function
+// Unmarshal. As the result is a copy, modifying it does not modify v.
+func (v Value) Unmarshal(into interface{}) error {
+	return UnmarshalString(v.String(), into)
+}
+
+// hashKey returns key if it can be used as a map key, otherwise its Value.
+func (d *Decoder) hashKey(key interface{}) interface{} {
+	if isHashable(reflect.ValueOf(key)) {
+		return key
+	}
+	v, err := NewValue(key)
+	if err != nil {
+		d.error(err)
+	}
+	return v
+}
+
+// isHashable reports whether v can be used as a map key without panicking.
+func isHashable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Slice, reflect.Map, reflect.Func:
+		return false
+	case reflect.Interface:
+		return v.IsNil() || isHashable(v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !isHashable(v.Index(i)) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isHashable(v.Field(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// UnhashableError is an error which occurs when the decoder attempted to assign
+// an unhashable key to a map or set. The position close to where value was
+// found is provided to help debugging.
+//
+// Deprecated: The decoder no longer returns UnhashableError, as unhashable
+// keys and elements are stored as Values.
+type UnhashableError struct {
+	Position int64
+}
+
+func (e *UnhashableError) Error() string {
+	return "edn: unhashable type at position " + strconv.FormatInt(e.Position, 10) + " in input"
+}
@@ -0,0 +1,163 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestValueKeys(t *testing.T) {
+	var m map[interface{}]interface{}
+	if err := UnmarshalString(`{[1 2] :a, {:x 1 :y 2} :b, #{1 2} :c, :d 4, [1N] :e}`, &m); err != nil {
+		t.Fatal(err)
+	}
+	lookups := []struct {
+		key      interface{}
+		expected interface{}
+	}{
+		{[]int{1, 2}, Keyword("a")},
+		{map[Keyword]int{"y": 2, "x": 1}, Keyword("b")},
+		{map[int]bool{2: true, 1: true}, Keyword("c")},
+		{[]int{1}, Keyword("e")},
+	}
+	for _, lookup := range lookups {
+		key, err := NewValue(lookup.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m[key] != lookup.expected {
+			t.Errorf("Expected %v to map to %v, got %v", key, lookup.expected, m[key])
+		}
+	}
+	if m[Keyword("d")] != int64(4) {
+		t.Errorf("Expected hashable keys to be stored as is, got %#v", m)
+	}
+}
+
+func TestValueKeysBigInt(t *testing.T) {
+	var m map[interface{}]interface{}
+	if err := UnmarshalString(`{1 :a 1N :b}`, &m); err != nil {
+		t.Fatal(err)
+	}
+	one, _ := NewValue(big.NewInt(1))
+	if len(m) != 2 || m[int64(1)] != Keyword("a") || m[one] != Keyword("b") {
+		t.Errorf("Expected 1 and 1N to be distinct keys, got %#v", m)
+	}
+}
+
+func TestValueSetOfMaps(t *testing.T) {
+	var set map[interface{}]bool
+	if err := UnmarshalString(`#{{:a 1 :b 2} {:a 2} [{:c 3}]}`, &set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 3 {
+		t.Errorf("Expected 3 elements, got %d", len(set))
+	}
+	key, _ := NewValue([]interface{}{map[interface{}]interface{}{Keyword("c"): 3}})
+	if !set[key] {
+		t.Errorf("Expected %v in %v", key, set)
+	}
+	bs, err := MarshalSorted(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != `#{[{:c 3}] {:a 1,:b 2} {:a 2}}` {
+		t.Errorf("Expected set to round trip, got %s", bs)
+	}
+}
+
+func TestValueUnmarshal(t *testing.T) {
+	var v Value
+	if v.String() != "nil" {
+		t.Errorf("Expected zero Value to be nil, got %s", v)
+	}
+	if nilVal, _ := NewValue(nil); nilVal != v {
+		t.Errorf("Expected NewValue(nil) to be the zero Value, got %s", nilVal)
+	}
+	if err := UnmarshalString(`{:b [1 2], :a "x"}`, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != `{:a"x":b[1 2]}` {
+		t.Errorf("Expected canonical encoding, got %s", v)
+	}
+	var s struct {
+		A string
+		B []int
+	}
+	if err := v.Unmarshal(&s); err != nil {
+		t.Fatal(err)
+	}
+	if s.A != "x" || len(s.B) != 2 {
+		t.Errorf("Expected Value to unmarshal into struct, got %+v", s)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	var decoded interface{}
+	if err := UnmarshalString(`{[1 2] #{:a :b}, {:x 1} (1.0 2N)}`, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	vec := []interface{}{int64(1), int64(2)}
+	xmap := map[Keyword]int{"x": 1}
+	tests := []struct {
+		a, b  interface{}
+		equal bool
+	}{
+		{decoded, map[interface{}]interface{}{
+			&vec:  map[interface{}]bool{Keyword("b"): true, Keyword("a"): true},
+			&xmap: []interface{}{1.0, big.NewInt(2)},
+		}, true},
+		{map[interface{}]bool{Keyword("a"): true, Keyword("b"): true},
+			Set{Keyword("b"): true, Keyword("a"): true}, true},
+		{map[string]int{"a": 1}, map[string]int{"a": 2}, false},
+		{map[string]int{"a": 1}, map[string]int{"a": 1, "b": 2}, false},
+		{int64(1), 1.0, false},
+		{int64(1), big.NewInt(1), true},
+		{new(big.Int).Lsh(big.NewInt(1), 64), new(big.Int).Lsh(big.NewInt(1), 64), true},
+		{int64(1), 1, true},
+		{'a', Rune('a'), false},
+		{Symbol("a"), Keyword("a"), false},
+		{List{1}, Vector{1}, false},
+		{Tag{"foo", []int{1}}, Tag{"foo", Vector{1}}, true},
+		{nil, (*int)(nil), true},
+	}
+	for _, test := range tests {
+		if eq := Equal(test.a, test.b); eq != test.equal {
+			t.Errorf("Expected Equal(%v, %v) to be %v, was %v", test.a, test.b, test.equal, eq)
+		}
+		if eq := Equal(test.b, test.a); eq != test.equal {
+			t.Errorf("Expected Equal(%v, %v) to be %v, was %v", test.b, test.a, test.equal, eq)
+		}
+		if c := Compare(test.a, test.b); (c == 0) != test.equal || c != -Compare(test.b, test.a) {
+			t.Errorf("Inconsistent Compare(%v, %v): %d", test.a, test.b, c)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	sorted := []interface{}{
+		nil, false, true, math.Inf(-1), -1, 0.5, int64(1), 1.0, big.NewRat(3, 2), big.NewInt(2),
+		math.Inf(1), math.NaN(), Rune('a'), "a",
+		Symbol("a"), Keyword("a"), Keyword("b"), Tag{"a", 1}, List{1},
+		[]int{1}, []int{1, 2}, []int{2}, map[int]int{1: 1},
+		map[int]bool{1: true}, map[int]bool{1: true, 2: true},
+	}
+	for i := range sorted {
+		for j := range sorted {
+			expected := 0
+			switch {
+			case i < j:
+				expected = -1
+			case i > j:
+				expected = 1
+			}
+			if c := Compare(sorted[i], sorted[j]); c != expected {
+				t.Errorf("Expected Compare(%v, %v) to be %d, was %d", sorted[i], sorted[j], expected, c)
+			}
+		}
+	}
+}
@@ -0,0 +1,440 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+var (
+	ErrMismatchedEnd  = errors.New("edn: collection end does not match collection start")
+	ErrOddMapEntries  = errors.New("edn: map has a key without a value")
+	ErrDanglingTag    = errors.New("edn: tag is not followed by a value")
+	ErrDanglingMeta   = errors.New("edn: metadata is not followed by a value")
+	ErrUnclosed       = errors.New("edn: collection or tag is not closed")
+	ErrInvalidTag     = errors.New("edn: invalid tag name")
+	ErrInvalidRaw     = errors.New("edn: raw input is not a single EDN value")
+	ErrInvalidKeyword = errors.New("edn: invalid keyword")
+	ErrInvalidSymbol  = errors.New("edn: invalid symbol")
+)
+
+// writerFlushSize is the buffer size at which a Writer flushes its buffered
+// output, even though it is in the middle of writing a value.
+const writerFlushSize = 4096
+
+// A Writer writes EDN values token by token to an output stream, without
+// using reflection. The Writer keeps track of open collections and tags, and
+// returns an error if the calls made to it would produce invalid EDN, such as
+// closing a vector with EndMap or ending a map with an odd number of elements.
+//
+// Like Encoder, a Writer writes a newline after every top level value. Output
+// is buffered, and is written to the underlying writer whenever a top level
+// value is complete, when the buffer grows large, or when Flush is called.
+//
+// Once a Writer has returned an error, all subsequent calls will return the
+// same error.
+type Writer struct {
+	writer io.Writer
+	ec     encodeState
+	toks   *tokenStack
+	err    error
+}
+
+// NewWriter returns a new Writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		writer: w,
+		toks:   newTokenStack(),
+	}
+}
+
+// BeginMap writes the start of a map. Elements written until the matching
+// EndMap are alternately keys and values.
+func (w *Writer) BeginMap() error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	w.ec.WriteByte('{')
+	w.ec.needsDelim = false
+	return w.push(tokenMapStart)
+}
+
+// EndMap writes the end of the map started by the last open BeginMap.
+func (w *Writer) EndMap() error {
+	if err := w.beforeEnd(tokenMapStart); err != nil {
+		return err
+	}
+	if w.toks.peekCount()%2 != 0 {
+		return w.fail(ErrOddMapEntries)
+	}
+	w.ec.WriteByte('}')
+	w.ec.needsDelim = false
+	return w.push(tokenMapEnd)
+}
+
+// BeginVector writes the start of a vector.
+func (w *Writer) BeginVector() error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	w.ec.WriteByte('[')
+	w.ec.needsDelim = false
+	return w.push(tokenVectorStart)
+}
+
+// EndVector writes the end of the vector started by the last open
+// BeginVector.
+func (w *Writer) EndVector() error {
+	if err := w.beforeEnd(tokenVectorStart); err != nil {
+		return err
+	}
+	w.ec.WriteByte(']')
+	w.ec.needsDelim = false
+	return w.push(tokenVectorEnd)
+}
+
+// BeginList writes the start of a list.
+func (w *Writer) BeginList() error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	w.ec.WriteByte('(')
+	w.ec.needsDelim = false
+	return w.push(tokenListStart)
+}
+
+// EndList writes the end of the list started by the last open BeginList.
+func (w *Writer) EndList() error {
+	if err := w.beforeEnd(tokenListStart); err != nil {
+		return err
+	}
+	w.ec.WriteByte(')')
+	w.ec.needsDelim = false
+	return w.push(tokenListEnd)
+}
+
+// BeginSet writes the start of a set. The Writer does not check that the
+// elements of the set are unique.
+func (w *Writer) BeginSet() error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	w.ec.ensureDelim()
+	w.ec.WriteString("#{")
+	w.ec.needsDelim = false
+	return w.push(tokenSetStart)
+}
+
+// EndSet writes the end of the set started by the last open BeginSet.
+func (w *Writer) EndSet() error {
+	if err := w.beforeEnd(tokenSetStart); err != nil {
+		return err
+	}
+	w.ec.WriteByte('}')
+	w.ec.needsDelim = false
+	return w.push(tokenSetEnd)
+}
+
+// Nil writes the EDN value nil.
+func (w *Writer) Nil() error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	w.ec.writeNil()
+	return w.push(tokenSymbol)
+}
+
+// Bool writes b as an EDN boolean.
+func (w *Writer) Bool(b bool) error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	w.ec.ensureDelim()
+	if b {
+		w.ec.WriteString("true")
+	} else {
+		w.ec.WriteString("false")
+	}
+	w.ec.needsDelim = true
+	return w.push(tokenSymbol)
+}
+
+// Keyword writes k as an EDN keyword. k must not contain the leading colon.
+// It returns ErrInvalidKeyword if k is not a valid keyword.
+func (w *Writer) Keyword(k string) error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	if !isValidName(":"+k, tokenKeyword) {
+		return w.fail(ErrInvalidKeyword)
+	}
+	w.ec.ensureDelim()
+	w.ec.WriteByte(':')
+	w.ec.WriteString(k)
+	w.ec.needsDelim = true
+	return w.push(tokenKeyword)
+}
+
+// Symbol writes s as an EDN symbol. It returns ErrInvalidSymbol if s is not a
+// valid symbol.
+func (w *Writer) Symbol(s string) error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	if !isValidName(s, tokenSymbol) {
+		return w.fail(ErrInvalidSymbol)
+	}
+	w.ec.ensureDelim()
+	w.ec.WriteString(s)
+	w.ec.needsDelim = true
+	return w.push(tokenSymbol)
+}
+
+// String writes s as an EDN string, escaped the same way Marshal escapes
+// strings.
+func (w *Writer) String(s string) error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	w.ec.string(s)
+	return w.push(tokenString)
+}
+
+// Char writes r as an EDN character.
+func (w *Writer) Char(r rune) error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	w.ec.ensureDelim()
+	encodeRune(&w.ec.Buffer, r)
+	w.ec.needsDelim = true
+	return w.push(tokenChar)
+}
+
+// Int writes n as an EDN integer.
+func (w *Writer) Int(n int64) error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	w.ec.ensureDelim()
+	w.ec.Write(strconv.AppendInt(w.ec.scratch[:0], n, 10))
+	w.ec.needsDelim = true
+	return w.push(tokenInt)
+}
+
+// Uint writes n as an EDN integer.
+func (w *Writer) Uint(n uint64) error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	w.ec.ensureDelim()
+	w.ec.Write(strconv.AppendUint(w.ec.scratch[:0], n, 10))
+	w.ec.needsDelim = true
+	return w.push(tokenInt)
+}
+
+// BigInt writes n as an EDN integer with the N suffix.
+func (w *Writer) BigInt(n *big.Int) error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	w.ec.ensureDelim()
+	w.ec.WriteString(n.String())
+	w.ec.WriteByte('N')
+	w.ec.needsDelim = true
+	return w.push(tokenInt)
+}
+
+// Float writes f as an EDN float. If f is NaN or an infinity, Float returns
+// an UnsupportedValueError.
+func (w *Writer) Float(f float64) error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return w.fail(&UnsupportedValueError{reflect.ValueOf(f), strconv.FormatFloat(f, 'g', -1, 64)})
+	}
+	w.ec.float(f, 64)
+	return w.push(tokenFloat)
+}
+
+// Tag writes the tag #tagname. The next value written is the value of the
+// tag.
+func (w *Writer) Tag(tagname string) error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	if !isValidTagName(tagname) {
+		return w.fail(ErrInvalidTag)
+	}
+	w.ec.ensureDelim()
+	w.ec.WriteByte('#')
+	w.ec.WriteString(tagname)
+	w.ec.needsDelim = true
+	return w.push(tokenTag)
+}
+
+// Meta writes ^, the start of metadata. The next value written is the
+// metadata, and the value after it is the value the metadata is attached to.
+func (w *Writer) Meta() error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	w.ec.ensureDelim()
+	w.ec.WriteByte('^')
+	w.ec.needsDelim = false
+	return w.push(tokenMeta)
+}
+
+// Raw writes the EDN-encoded value b verbatim, except that whitespace and
+// comments are compacted. b must contain exactly one EDN value, otherwise Raw
+// returns ErrInvalidRaw.
+func (w *Writer) Raw(b []byte) error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	if !isSingleValue(b) {
+		return w.fail(ErrInvalidRaw)
+	}
+	w.ec.ensureDelim()
+	if err := Compact(&w.ec.Buffer, b); err != nil {
+		return w.fail(err)
+	}
+	w.ec.needsDelim = true
+	return w.push(tokenSymbol)
+}
+
+// Value writes the EDN encoding of v, as Marshal would encode it. This can be
+// used to write values that don't have a dedicated method on the Writer.
+func (w *Writer) Value(v interface{}) error {
+	if err := w.beforeValue(); err != nil {
+		return err
+	}
+	origLen := w.ec.Len()
+	if err := w.ec.marshal(v); err != nil {
+		w.ec.Truncate(origLen)
+		return w.fail(err)
+	}
+	return w.push(tokenSymbol)
+}
+
+// Flush writes any buffered output to the underlying writer.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.ec.Len() == 0 {
+		return nil
+	}
+	_, err := w.writer.Write(w.ec.Bytes())
+	w.ec.Reset()
+	if err != nil {
+		return w.fail(err)
+	}
+	return nil
+}
+
+// Close flushes any buffered output, and returns ErrUnclosed if there are
+// collections or tags that have not yet been closed. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(w.toks.toks) > 0 {
+		return w.fail(ErrUnclosed)
+	}
+	return nil
+}
+
+func (w *Writer) fail(err error) error {
+	w.err = err
+	return err
+}
+
+// beforeValue prepares the output for a new value.
+func (w *Writer) beforeValue() error {
+	if w.err != nil {
+		return w.err
+	}
+	toks := w.toks.toks
+	if len(toks) > 0 {
+		top := toks[len(toks)-1]
+		// like mapEncoder, use commas between map entries instead of whitespace
+		if top.tt == tokenMapStart && top.count > 0 && top.count%2 == 0 && w.ec.needsDelim {
+			w.ec.WriteByte(',')
+			w.ec.needsDelim = false
+		}
+	}
+	return nil
+}
+
+// beforeEnd checks that the innermost open collection was started with start.
+func (w *Writer) beforeEnd(start tokenType) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.toks.toks) == 0 {
+		return w.fail(ErrMismatchedEnd)
+	}
+	switch w.toks.peek() {
+	case start:
+		return nil
+	case tokenTag:
+		return w.fail(ErrDanglingTag)
+	case tokenMeta:
+		return w.fail(ErrDanglingMeta)
+	default:
+		return w.fail(ErrMismatchedEnd)
+	}
+}
+
+// push records the token just written, terminates top level values and
+// flushes the output when appropriate.
+func (w *Writer) push(tt tokenType) error {
+	if err := w.toks.push(tt); err != nil {
+		return w.fail(err)
+	}
+	if len(w.toks.toks) == 0 {
+		w.ec.WriteByte('\n')
+		w.ec.needsDelim = false
+		return w.Flush()
+	}
+	if w.ec.Len() >= writerFlushSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+// isSingleValue reports whether b contains exactly one EDN value, apart from
+// whitespace, comments and discarded values.
+func isSingleValue(b []byte) bool {
+	d := newDecoder(bufio.NewReader(bytes.NewReader(b)))
+	d.metadata = passMetadata
+	return d.traverseValue() == nil && d.more() == io.EOF
+}
+
+// isValidTagName reports whether s can be used as a tag name, that is, if it
+// is a symbol starting with a letter.
+func isValidTagName(s string) bool {
+	var lex lexer
+	lex.reset()
+	if lex.state('#') != lexCont {
+		return false
+	}
+	for _, r := range s {
+		if lex.state(r) != lexCont {
+			return false
+		}
+	}
+	return lex.eof() == lexEnd && lex.token == tokenTag
+}
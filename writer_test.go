@@ -0,0 +1,122 @@
+// Copyright 2015 Jean Niklas L'orange.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.BeginMap()
+	w.Keyword("name")
+	w.String("Hans")
+	w.Keyword("tags")
+	w.BeginSet()
+	w.Symbol("a")
+	w.Symbol("b")
+	w.EndSet()
+	w.Symbol("pets")
+	w.BeginVector()
+	w.Int(1)
+	w.Float(2)
+	w.Char('c')
+	w.Char('d')
+	w.Nil()
+	w.Bool(true)
+	w.Tag("inst")
+	w.String("2015-01-01T00:00:00Z")
+	w.Raw([]byte("(x   y)"))
+	w.EndVector()
+	w.EndMap()
+	w.BeginList()
+	w.Uint(3)
+	w.Value([]int{4, 5})
+	if err := w.EndList(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{:name"Hans":tags #{a b}pets[1 2.0 \c \d nil true #inst"2015-01-01T00:00:00Z"(x y)]}` + "\n" +
+		"(3[4 5])\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+	d := NewDecoder(&buf)
+	for i := 0; i < 2; i++ {
+		var v interface{}
+		if err := d.Decode(&v); err != nil {
+			t.Errorf("Writer output could not be read back: %s", err)
+		}
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.BeginVector()
+	if err := w.EndMap(); err != ErrMismatchedEnd {
+		t.Errorf("Expected ErrMismatchedEnd, got %v", err)
+	}
+	if err := w.Int(1); err != ErrMismatchedEnd {
+		t.Errorf("Expected errors to be sticky, got %v", err)
+	}
+
+	w = NewWriter(&buf)
+	w.BeginMap()
+	w.Keyword("a")
+	if err := w.EndMap(); err != ErrOddMapEntries {
+		t.Errorf("Expected ErrOddMapEntries, got %v", err)
+	}
+
+	w = NewWriter(&buf)
+	w.BeginSet()
+	w.Tag("foo")
+	if err := w.EndSet(); err != ErrDanglingTag {
+		t.Errorf("Expected ErrDanglingTag, got %v", err)
+	}
+
+	w = NewWriter(&buf)
+	if err := w.Tag("1foo"); err != ErrInvalidTag {
+		t.Errorf("Expected ErrInvalidTag, got %v", err)
+	}
+
+	for _, raw := range []string{"", "1 2", "]", "[1", "#_1", ";x"} {
+		w = NewWriter(&buf)
+		w.BeginVector()
+		if err := w.Raw([]byte(raw)); err != ErrInvalidRaw {
+			t.Errorf("Expected ErrInvalidRaw for %q, got %v", raw, err)
+		}
+	}
+	w = NewWriter(&buf)
+	if err := w.Raw([]byte("#_0 ^:a #foo [1 2] ; x")); err != nil {
+		t.Errorf("Expected a single value to be valid raw input, got %v", err)
+	}
+
+	w = NewWriter(&buf)
+	w.BeginList()
+	if err := w.Close(); err != ErrUnclosed {
+		t.Errorf("Expected ErrUnclosed, got %v", err)
+	}
+
+	w = NewWriter(&buf)
+	if _, ok := w.Float(math.NaN()).(*UnsupportedValueError); !ok {
+		t.Error("Expected NaN to be an unsupported value")
+	}
+
+	w = NewWriter(&buf)
+	w.BeginVector()
+	err := w.Value(make(chan int))
+	if _, ok := err.(*UnsupportedTypeError); !ok {
+		t.Errorf("Expected an UnsupportedTypeError, got %v", err)
+	}
+	if err2 := w.Int(3); err2 != err {
+		t.Errorf("Expected errors from Value to be sticky, got %v", err2)
+	}
+}